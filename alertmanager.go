@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AlertRule Threshold on an in-plugin metric value that must hold for a duration before firing
+type AlertRule struct {
+	Name      string
+	Expr      *MetricExpr
+	Operator  string
+	Threshold float64
+	For       time.Duration
+	Text      string
+	active    map[string]*alertState
+}
+
+type alertState struct {
+	labels   map[string]string
+	value    float64
+	since    time.Time
+	firing   bool
+	startsAt time.Time
+}
+
+// ParseAlertRule Parse NAME EXPR OP THRESHOLD [for DURATION], Ex. HighErrorRate rate(errors_total[1m]) > 10/s for 2m
+func ParseAlertRule(s string) (*AlertRule, error) {
+	f := SplitUnquoted(s, unicode.IsSpace)
+	if len(f) != 4 && !(len(f) == 6 && f[4] == "for") {
+		return nil, fmt.Errorf("alert rule %q is not NAME EXPR OP THRESHOLD [for DURATION]", s)
+	}
+
+	r := &AlertRule{Name: f[0], Operator: f[2], Text: s, active: make(map[string]*alertState)}
+
+	expr, threshold, err := ParseThreshold(f[1], f[2], f[3])
+	if err != nil {
+		return nil, fmt.Errorf("alert rule %s: %v", r.Name, err)
+	}
+	r.Expr, r.Threshold = expr, threshold
+
+	if len(f) == 6 {
+		r.For, err = time.ParseDuration(f[5])
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %s: %v", r.Name, err)
+		}
+	}
+	return r, nil
+}
+
+// amAlert Alert as posted to the Alertmanager v2 API
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Alerter Evaluates alert rules against the plugin registry on a ticker and posts firing and
+// resolved alerts to an Alertmanager compatible API
+type Alerter struct {
+	url      string
+	interval time.Duration
+	rules    []*AlertRule
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   log.Logger
+	// pending Resolved alerts whose POST failed, resent until one succeeds
+	pending map[string]amAlert
+	stop    chan struct{}
+}
+
+func NewAlerter(p *PluginContext, gatherer prometheus.Gatherer) *Alerter {
+	url := strings.TrimSuffix(p.AlertmanagerURL, "/")
+	if !strings.HasSuffix(url, "/api/v2/alerts") {
+		url += "/api/v2/alerts"
+	}
+
+	return &Alerter{
+		url:      url,
+		interval: p.AlertInterval,
+		rules:    p.AlertRules,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   p.Logger,
+		pending:  make(map[string]amAlert),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (a *Alerter) Run() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := a.evaluate(now); err != nil {
+				level.Error(a.logger).Log("msg", "Alert evaluation failed", "url", a.url, "err", err)
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Stop End the evaluation ticker goroutine when the plugin exits
+func (a *Alerter) Stop() {
+	close(a.stop)
+}
+
+// evaluate Run every rule once and send the alerts that are firing or just resolved
+func (a *Alerter) evaluate(now time.Time) error {
+	samples, err := GatherSamples(a.gatherer)
+	if err != nil {
+		return err
+	}
+
+	var alerts []amAlert
+	for _, r := range a.rules {
+		alerts = append(alerts, r.evaluate(samples, now, a.interval)...)
+	}
+
+	// Resend resolves that never reached Alertmanager, unless the alert is back in this batch
+	current := make(map[string]bool, len(alerts))
+	for _, al := range alerts {
+		current[al.key()] = true
+	}
+	for key, al := range a.pending {
+		if current[key] {
+			delete(a.pending, key)
+			continue
+		}
+		alerts = append(alerts, al)
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	if err := a.post(alerts); err != nil {
+		for _, al := range alerts {
+			if !al.EndsAt.After(now) {
+				a.pending[al.key()] = al
+			}
+		}
+		return err
+	}
+	a.pending = make(map[string]amAlert)
+	return nil
+}
+
+func (a *Alerter) post(alerts []amAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// key Identify the alert by its labels, as Alertmanager does
+func (al amAlert) key() string {
+	return Sample{Labels: al.Labels}.key()
+}
+
+func (r *AlertRule) evaluate(samples []Sample, now time.Time, interval time.Duration) []amAlert {
+	var alerts []amAlert
+	seen := make(map[string]bool)
+
+	for _, s := range r.Expr.Eval(samples, now) {
+		if !compareThreshold(s.Value, r.Operator, r.Threshold) {
+			continue
+		}
+		key := s.key()
+		seen[key] = true
+
+		st, ok := r.active[key]
+		if !ok {
+			st = &alertState{labels: s.Labels, since: now}
+			r.active[key] = st
+		}
+		st.value = s.Value
+
+		if !st.firing && now.Sub(st.since) >= r.For {
+			st.firing = true
+			st.startsAt = now
+		}
+		if st.firing {
+			// Alertmanager resolves alerts that are not resent before endsAt
+			alerts = append(alerts, r.alert(st, now.Add(4*interval)))
+		}
+	}
+
+	for key, st := range r.active {
+		if seen[key] {
+			continue
+		}
+		if st.firing {
+			alerts = append(alerts, r.alert(st, now))
+		}
+		delete(r.active, key)
+	}
+	return alerts
+}
+
+func (r *AlertRule) alert(st *alertState, endsAt time.Time) amAlert {
+	labels := make(map[string]string, len(st.labels)+1)
+	for k, v := range st.labels {
+		labels[k] = v
+	}
+	labels["alertname"] = r.Name
+
+	return amAlert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary": r.Text,
+			"value":   strconv.FormatFloat(st.value, 'g', -1, 64),
+		},
+		StartsAt: st.startsAt,
+		EndsAt:   endsAt,
+	}
+}
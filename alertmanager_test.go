@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      string
+		wantErr   bool
+		metric    string
+		matchers  map[string]string
+		operator  string
+		threshold float64
+		rate      bool
+		window    time.Duration
+		forDur    time.Duration
+	}{
+		{
+			name:      "plain threshold",
+			rule:      "QueueFull queue_depth >= 100",
+			metric:    "queue_depth",
+			matchers:  map[string]string{},
+			operator:  ">=",
+			threshold: 100,
+		},
+		{
+			name:      "rate with window and for",
+			rule:      "HighErrorRate rate(errors_total[1m]) > 10/s for 2m",
+			metric:    "errors_total",
+			matchers:  map[string]string{},
+			operator:  ">",
+			threshold: 10,
+			rate:      true,
+			window:    time.Minute,
+			forDur:    2 * time.Minute,
+		},
+		{
+			name:      "label value with spaces and commas",
+			rule:      `Timeouts errors_total{msg="timed out, retrying", code="504"} > 0`,
+			metric:    "errors_total",
+			matchers:  map[string]string{"msg": "timed out, retrying", "code": "504"},
+			operator:  ">",
+			threshold: 0,
+		},
+		{name: "too few fields", rule: "Broken errors_total >", wantErr: true},
+		{name: "bad operator", rule: "Broken errors_total => 1", wantErr: true},
+		{name: "bad for", rule: "Broken errors_total > 1 for soon", wantErr: true},
+		{name: "missing for keyword", rule: "Broken errors_total > 1 during 2m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseAlertRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAlertRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if r.Expr.Selector.Name != tt.metric || r.Operator != tt.operator || r.Threshold != tt.threshold {
+				t.Errorf("got %s %s %v, want %s %s %v", r.Expr.Selector.Name, r.Operator, r.Threshold, tt.metric, tt.operator, tt.threshold)
+			}
+			if len(r.Expr.Selector.Matchers) != len(tt.matchers) {
+				t.Errorf("matchers = %v, want %v", r.Expr.Selector.Matchers, tt.matchers)
+			}
+			for k, v := range tt.matchers {
+				if r.Expr.Selector.Matchers[k] != v {
+					t.Errorf("matcher %s = %q, want %q", k, r.Expr.Selector.Matchers[k], v)
+				}
+			}
+			if r.Expr.Rate != tt.rate || r.Expr.Window != tt.window || r.For != tt.forDur {
+				t.Errorf("rate/window/for = %v/%v/%v, want %v/%v/%v", r.Expr.Rate, r.Expr.Window, r.For, tt.rate, tt.window, tt.forDur)
+			}
+		})
+	}
+}
+
+func TestAlerterEvaluate(t *testing.T) {
+	var posts [][]amAlert
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("posted to %s, want /api/v2/alerts", r.URL.Path)
+		}
+		var alerts []amAlert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Errorf("decoding alerts: %v", err)
+		}
+		posts = append(posts, alerts)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	depth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queue_depth", Help: "test"}, []string{"queue"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(depth)
+
+	rule, err := ParseAlertRule("QueueFull queue_depth > 10 for 1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.AlertmanagerURL = srv.URL
+	p.AlertInterval = 15 * time.Second
+	p.AlertRules = []*AlertRule{rule}
+	a := NewAlerter(p, registry)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		at        time.Duration
+		depth     float64
+		status    int
+		wantErr   bool
+		wantPosts int
+		// wantState of each posted alert, firing or resolved
+		wantState []string
+	}{
+		{"below threshold", 0, 5, http.StatusOK, false, 0, nil},
+		{"pending for duration", 15 * time.Second, 20, http.StatusOK, false, 0, nil},
+		{"firing after for", 90 * time.Second, 20, http.StatusOK, false, 1, []string{"firing"}},
+		{"still firing is resent", 105 * time.Second, 25, http.StatusOK, false, 2, []string{"firing"}},
+		{"resolve post fails", 120 * time.Second, 5, http.StatusInternalServerError, true, 3, []string{"resolved"}},
+		{"pending resolve resent", 135 * time.Second, 5, http.StatusOK, false, 4, []string{"resolved"}},
+		{"nothing left to send", 150 * time.Second, 5, http.StatusOK, false, 4, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			depth.WithLabelValues("orders").Set(tt.depth)
+			status = tt.status
+			now := start.Add(tt.at)
+
+			err := a.evaluate(now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(posts) != tt.wantPosts {
+				t.Fatalf("%d posts, want %d", len(posts), tt.wantPosts)
+			}
+			if tt.wantState == nil {
+				return
+			}
+
+			last := posts[len(posts)-1]
+			if len(last) != len(tt.wantState) {
+				t.Fatalf("%d alerts posted, want %d", len(last), len(tt.wantState))
+			}
+			for i, al := range last {
+				if al.Labels["alertname"] != "QueueFull" || al.Labels["queue"] != "orders" {
+					t.Errorf("alert labels = %v", al.Labels)
+				}
+				if !al.StartsAt.Equal(start.Add(90 * time.Second)) {
+					t.Errorf("startsAt = %v, want the first firing evaluation", al.StartsAt)
+				}
+				state := "firing"
+				if !al.EndsAt.After(now) {
+					state = "resolved"
+				}
+				if state != tt.wantState[i] {
+					t.Errorf("alert %d is %s (endsAt %v), want %s", i, state, al.EndsAt, tt.wantState[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAlerterPendingResolveDroppedWhenFiringAgain(t *testing.T) {
+	var posts [][]amAlert
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alerts []amAlert
+		json.NewDecoder(r.Body).Decode(&alerts)
+		posts = append(posts, alerts)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth", Help: "test"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(depth)
+
+	rule, err := ParseAlertRule("QueueFull queue_depth > 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.AlertmanagerURL = srv.URL + "/api/v2/alerts"
+	p.AlertInterval = 15 * time.Second
+	p.AlertRules = []*AlertRule{rule}
+	a := NewAlerter(p, registry)
+
+	now := time.Now()
+	depth.Set(20)
+	if err := a.evaluate(now); err != nil {
+		t.Fatal(err)
+	}
+
+	depth.Set(0)
+	status = http.StatusServiceUnavailable
+	if err := a.evaluate(now.Add(15 * time.Second)); err == nil {
+		t.Fatal("evaluate() succeeded against a failing Alertmanager")
+	}
+
+	depth.Set(20)
+	status = http.StatusOK
+	if err := a.evaluate(now.Add(30 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	last := posts[len(posts)-1]
+	if len(last) != 1 || !last[0].EndsAt.After(now.Add(30*time.Second)) {
+		t.Errorf("posted %v, want only the firing alert", last)
+	}
+	if len(a.pending) != 0 {
+		t.Errorf("%d pending resolves left, want 0", len(a.pending))
+	}
+}
+
+func TestAlerterStop(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer srv.Close()
+
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth", Help: "test"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(depth)
+	depth.Set(20)
+
+	rule, err := ParseAlertRule("QueueFull queue_depth > 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.AlertmanagerURL = srv.URL
+	p.AlertInterval = 10 * time.Millisecond
+	p.AlertRules = []*AlertRule{rule}
+	a := NewAlerter(p, registry)
+
+	done := make(chan struct{})
+	go func() {
+		a.Run()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&posts) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	a.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+	stopped := atomic.LoadInt32(&posts)
+	if stopped == 0 {
+		t.Fatal("no alert posted before Stop")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&posts); n != stopped {
+		t.Errorf("%d posts after Stop, want none", n-stopped)
+	}
+}
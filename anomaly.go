@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// anomalyWarmup Intervals observed before a series may be flagged, so the mean and variance can settle
+const anomalyWarmup = 5
+
+type anomalySeries struct {
+	labelValues []string
+	current     float64
+	mean        float64
+	variance    float64
+	intervals   int
+	zscore      float64
+	anomalous   bool
+}
+
+// AnomalyCollector Keeps an exponentially weighted mean and variance of a per interval rate for each
+// label set and flags intervals whose z-score exceeds the threshold.  Intervals are closed by a ticker.
+type AnomalyCollector struct {
+	mu            sync.Mutex
+	labelNames    []string
+	alpha         float64
+	threshold     float64
+	series        map[string]*anomalySeries
+	zscoreDesc    *prometheus.Desc
+	anomalousDesc *prometheus.Desc
+	logEvents     bool
+	logger        log.Logger
+	stop          chan struct{}
+}
+
+type FBAnomaly struct {
+	Handle *AnomalyCollector
+}
+
+func (a *FBAnomaly) NewMetric(p *PluginContext) {
+	a.Handle = &AnomalyCollector{
+		labelNames:    p.VariableLabels,
+		alpha:         p.Anomaly.Alpha,
+		threshold:     p.Anomaly.Threshold,
+		series:        make(map[string]*anomalySeries),
+		zscoreDesc:    prometheus.NewDesc(p.Name+"_anomaly_zscore", "z-score of the last interval of "+p.Name+" against its exponentially weighted history", p.VariableLabels, p.ConstantLabels),
+		anomalousDesc: prometheus.NewDesc(p.Name+"_anomalous", "1 when the z-score of "+p.Name+" exceeds the anomaly threshold", p.VariableLabels, p.ConstantLabels),
+		logEvents:     p.Anomaly.Events == "Log",
+		logger:        p.Logger,
+		stop:          make(chan struct{}),
+	}
+
+	go a.Handle.run(p.Anomaly.Interval)
+}
+
+func (a *AnomalyCollector) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.advance()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Stop End the ticker goroutine when the plugin exits
+func (a *AnomalyCollector) Stop() {
+	close(a.stop)
+}
+
+// Observe Add v to the current interval of the label set, 1 per record when counting rates
+func (a *AnomalyCollector) Observe(labels prometheus.Labels, v float64) {
+	values := labelValues(labels, a.labelNames)
+	key := labelsKey(values)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.series[key]
+	if !ok {
+		s = &anomalySeries{labelValues: values}
+		a.series[key] = s
+	}
+	s.current += v
+}
+
+// advance Score the interval that just ended against the history, then fold it into the history
+func (a *AnomalyCollector) advance() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, s := range a.series {
+		x := s.current
+		s.current = 0
+
+		if s.intervals == 0 {
+			s.mean = x
+		} else {
+			s.zscore = 0
+			if sd := math.Sqrt(s.variance); sd > 0 {
+				s.zscore = (x - s.mean) / sd
+			} else if x != s.mean {
+				// A flat history makes any change infinitely surprising, cap it at the threshold
+				s.zscore = math.Copysign(a.threshold, x-s.mean)
+			}
+
+			diff := x - s.mean
+			incr := a.alpha * diff
+			s.mean += incr
+			s.variance = (1 - a.alpha) * (s.variance + diff*incr)
+		}
+		s.intervals++
+
+		anomalous := s.intervals > anomalyWarmup && math.Abs(s.zscore) >= a.threshold
+		if anomalous != s.anomalous && a.logEvents {
+			state := "resolved"
+			if anomalous {
+				state = "anomalous"
+			}
+			pairs := make([]string, len(a.labelNames))
+			for i, n := range a.labelNames {
+				pairs[i] = n + "=" + s.labelValues[i]
+			}
+			level.Warn(a.logger).Log("msg", "Anomaly "+state, "labels", strings.Join(pairs, ","), "value", x, "mean", s.mean, "zscore", s.zscore)
+		}
+		s.anomalous = anomalous
+
+		if x == 0 && s.mean < 1e-3 && !s.anomalous {
+			// The label set has gone quiet for good
+			delete(a.series, key)
+		}
+	}
+}
+
+func (a *AnomalyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.zscoreDesc
+	ch <- a.anomalousDesc
+}
+
+func (a *AnomalyCollector) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, s := range a.series {
+		if s.intervals == 0 {
+			continue
+		}
+		var anomalous float64
+		if s.anomalous {
+			anomalous = 1
+		}
+		ch <- prometheus.MustNewConstMetric(a.zscoreDesc, prometheus.GaugeValue, s.zscore, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(a.anomalousDesc, prometheus.GaugeValue, anomalous, s.labelValues...)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newAnomalyCollector() *AnomalyCollector {
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.Name = "test"
+	p.VariableLabels = []string{"route"}
+	p.Anomaly.Alpha = 0.3
+	p.Anomaly.Threshold = 3
+	// Intervals are closed by the test, not the ticker
+	p.Anomaly.Interval = time.Hour
+	var a FBAnomaly
+	a.NewMetric(p)
+	return a.Handle
+}
+
+func TestAnomalyCollectorAdvance(t *testing.T) {
+	tests := []struct {
+		name      string
+		intervals []float64
+		anomalous bool
+		tracked   bool
+	}{
+		{"steady", []float64{10, 10, 11, 9, 10, 10, 11, 10}, false, true},
+		{"spike after warmup", []float64{10, 10, 11, 9, 10, 10, 11, 100}, true, true},
+		{"spike during warmup ignored", []float64{10, 100}, false, true},
+		{"drop to zero", []float64{10, 10, 11, 9, 10, 10, 11, 0}, true, true},
+		{"quiet label set dropped", []float64{0.0001, 0, 0}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAnomalyCollector()
+			defer a.Stop()
+			labels := prometheus.Labels{"route": "/a"}
+			for _, v := range tt.intervals {
+				if v != 0 {
+					a.Observe(labels, v)
+				}
+				a.advance()
+			}
+
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			s, ok := a.series[labelsKey([]string{"/a"})]
+			if ok != tt.tracked {
+				t.Fatalf("tracked = %v, want %v", ok, tt.tracked)
+			}
+			if ok && s.anomalous != tt.anomalous {
+				t.Errorf("anomalous = %v (z-score %v), want %v", s.anomalous, s.zscore, tt.anomalous)
+			}
+		})
+	}
+}
+
+func TestAnomalyCollectorStop(t *testing.T) {
+	a := newAnomalyCollector()
+
+	done := make(chan struct{})
+	go func() {
+		a.run(time.Millisecond)
+		close(done)
+	}()
+	a.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after Stop")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// apdexBuckets Resolution of the trailing window
+const apdexBuckets = 10
+
+// Counts held per bucket of an Apdex series
+const (
+	apdexSatisfied = iota
+	apdexTolerating
+	apdexFrustrated
+)
+
+type apdexSeries struct {
+	labelValues []string
+	ring        *timeRing
+}
+
+// ApdexCollector Classifies latencies against a threshold T and exports the Apdex score
+// (satisfied + tolerating / 2) / total per label set over a trailing window.
+type ApdexCollector struct {
+	mu             sync.Mutex
+	labelNames     []string
+	threshold      float64
+	window         time.Duration
+	series         map[string]*apdexSeries
+	scoreDesc      *prometheus.Desc
+	satisfiedDesc  *prometheus.Desc
+	toleratingDesc *prometheus.Desc
+	frustratedDesc *prometheus.Desc
+}
+
+type FBApdex struct {
+	Handle *ApdexCollector
+}
+
+func (a *FBApdex) NewMetric(p *PluginContext) {
+	desc := func(suffix, help string) *prometheus.Desc {
+		return prometheus.NewDesc(p.Name+suffix, p.Help+" ("+help+")", p.VariableLabels, p.ConstantLabels)
+	}
+
+	a.Handle = &ApdexCollector{
+		labelNames:     p.VariableLabels,
+		threshold:      p.Apdex.Threshold,
+		window:         p.Apdex.Window,
+		series:         make(map[string]*apdexSeries),
+		scoreDesc:      desc("", "Apdex score over the window"),
+		satisfiedDesc:  desc("_satisfied", "records at or below T over the window"),
+		toleratingDesc: desc("_tolerating", "records above T and at or below 4T over the window"),
+		frustratedDesc: desc("_frustrated", "records above 4T over the window"),
+	}
+}
+
+func (a *ApdexCollector) Observe(labels prometheus.Labels, v float64) {
+	class := apdexFrustrated
+	switch {
+	case v <= a.threshold:
+		class = apdexSatisfied
+	case v <= 4*a.threshold:
+		class = apdexTolerating
+	}
+
+	values := labelValues(labels, a.labelNames)
+	key := labelsKey(values)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.series[key]
+	if !ok {
+		s = &apdexSeries{labelValues: values, ring: newTimeRing(a.window/apdexBuckets, a.window, 3)}
+		a.series[key] = s
+	}
+	s.ring.Add(time.Now(), class, 1)
+}
+
+func (a *ApdexCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.scoreDesc
+	ch <- a.satisfiedDesc
+	ch <- a.toleratingDesc
+	ch <- a.frustratedDesc
+}
+
+func (a *ApdexCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, s := range a.series {
+		sums := s.ring.Sum(now, a.window)
+		satisfied, tolerating, frustrated := sums[apdexSatisfied], sums[apdexTolerating], sums[apdexFrustrated]
+
+		total := satisfied + tolerating + frustrated
+		if total == 0 {
+			// Nothing in the window, forget the label set until it logs again
+			delete(a.series, key)
+			continue
+		}
+		score := (satisfied + tolerating/2) / total
+
+		ch <- prometheus.MustNewConstMetric(a.scoreDesc, prometheus.GaugeValue, score, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(a.satisfiedDesc, prometheus.GaugeValue, satisfied, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(a.toleratingDesc, prometheus.GaugeValue, tolerating, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(a.frustratedDesc, prometheus.GaugeValue, frustrated, s.labelValues...)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newApdexCollector() *ApdexCollector {
+	p := &PluginContext{}
+	p.Name = "test_apdex"
+	p.Help = "test"
+	p.VariableLabels = []string{"route"}
+	p.Apdex.Threshold = 0.5
+	p.Apdex.Window = 5 * time.Minute
+	var a FBApdex
+	a.NewMetric(p)
+	return a.Handle
+}
+
+func TestApdexCollector(t *testing.T) {
+	tests := []struct {
+		name       string
+		latencies  []float64
+		score      float64
+		satisfied  float64
+		tolerating float64
+		frustrated float64
+	}{
+		{name: "all satisfied", latencies: []float64{0.1, 0.5}, score: 1, satisfied: 2},
+		{name: "tolerating counts half", latencies: []float64{0.1, 0.6}, score: 0.75, satisfied: 1, tolerating: 1},
+		{name: "4T is still tolerating", latencies: []float64{2}, score: 0.5, tolerating: 1},
+		{name: "above 4T frustrated", latencies: []float64{0.1, 2.01}, score: 0.5, satisfied: 1, frustrated: 1},
+		{name: "mixed", latencies: []float64{0.2, 0.3, 1, 5}, score: 0.625, satisfied: 2, tolerating: 1, frustrated: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newApdexCollector()
+			for _, v := range tt.latencies {
+				a.Observe(prometheus.Labels{"route": "/a"}, v)
+			}
+
+			want := `
+# HELP test_apdex test (Apdex score over the window)
+# TYPE test_apdex gauge
+test_apdex{route="/a"} ` + strconv.FormatFloat(tt.score, 'g', -1, 64) + `
+# HELP test_apdex_frustrated test (records above 4T over the window)
+# TYPE test_apdex_frustrated gauge
+test_apdex_frustrated{route="/a"} ` + strconv.FormatFloat(tt.frustrated, 'g', -1, 64) + `
+# HELP test_apdex_satisfied test (records at or below T over the window)
+# TYPE test_apdex_satisfied gauge
+test_apdex_satisfied{route="/a"} ` + strconv.FormatFloat(tt.satisfied, 'g', -1, 64) + `
+# HELP test_apdex_tolerating test (records above T and at or below 4T over the window)
+# TYPE test_apdex_tolerating gauge
+test_apdex_tolerating{route="/a"} ` + strconv.FormatFloat(tt.tolerating, 'g', -1, 64) + `
+`
+			if err := testutil.CollectAndCompare(a, strings.NewReader(want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestApdexCollectorForgetsQuietLabelSets(t *testing.T) {
+	a := newApdexCollector()
+	a.Observe(prometheus.Labels{"route": "/b"}, 0.1)
+	// /a only logged before the window
+	ring := newTimeRing(a.window/apdexBuckets, a.window, 3)
+	ring.Add(time.Now().Add(-10*time.Minute), apdexSatisfied, 1)
+	a.series[labelsKey([]string{"/a"})] = &apdexSeries{labelValues: []string{"/a"}, ring: ring}
+
+	if got := testutil.CollectAndCount(a); got != 4 {
+		t.Errorf("collected %d series, want only the 4 of /b", got)
+	}
+	if _, ok := a.series[labelsKey([]string{"/a"})]; ok {
+		t.Error("label set without records in the window still held")
+	}
+}
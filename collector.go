@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// missingLabelValue Value a variable label takes when its field is missing from a record, as fmt prints nil
+const missingLabelValue = "<nil>"
+
+// pushGroupingLabel Label names the pusher sets from its grouping key.  A gathered metric carrying one
+// makes the whole push fail, so labels taken from records must not use them.
+func pushGroupingLabel(name string) bool {
+	return name == "job"
+}
+
+// labelValues Order the values of a label map to match the given label names
+func labelValues(labels prometheus.Labels, names []string) []string {
+	values := make([]string, len(names))
+	for i, n := range names {
+		values[i] = labels[n]
+	}
+	return values
+}
+
+// labelsKey Build a map key identifying a single label set
+func labelsKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+type timeBucket struct {
+	epoch  int64
+	counts []float64
+}
+
+// timeRing Counts kept in fixed width time buckets so any trailing window up to span can be summed
+type timeRing struct {
+	resolution time.Duration
+	buckets    []timeBucket
+}
+
+// newTimeRing Ring of buckets each holding width counts
+func newTimeRing(resolution, span time.Duration, width int) *timeRing {
+	// One extra bucket for the one still filling
+	r := &timeRing{resolution: resolution, buckets: make([]timeBucket, int(span/resolution)+1)}
+	for i := range r.buckets {
+		r.buckets[i].counts = make([]float64, width)
+	}
+	return r
+}
+
+func (r *timeRing) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(r.resolution)
+}
+
+// Add Add v to count i of the bucket covering t
+func (r *timeRing) Add(t time.Time, i int, v float64) {
+	epoch := r.epoch(t)
+	b := &r.buckets[epoch%int64(len(r.buckets))]
+	if b.epoch != epoch {
+		b.epoch = epoch
+		for j := range b.counts {
+			b.counts[j] = 0
+		}
+	}
+	b.counts[i] += v
+}
+
+// Sum Total each count over the trailing window ending at t
+func (r *timeRing) Sum(t time.Time, window time.Duration) []float64 {
+	epoch := r.epoch(t)
+	oldest := epoch - int64(window/r.resolution)
+
+	sums := make([]float64, len(r.buckets[0].counts))
+	for _, b := range r.buckets {
+		if b.epoch > oldest && b.epoch <= epoch {
+			for j, c := range b.counts {
+				sums[j] += c
+			}
+		}
+	}
+	return sums
+}
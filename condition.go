@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conditionOperators Two character operators first so <= is not read as <
+var conditionOperators = []string{"=~", "!~", "==", "!=", "<=", ">=", "<", ">"}
+
+type comparison struct {
+	key      string
+	operator string
+	value    string
+	number   float64
+	numeric  bool
+	re       *regexp.Regexp
+}
+
+// RecordCondition Boolean expression over record fields: comparisons joined by && and ||, && binding tighter.
+// Ex. status_code >= 500 || latency > 0.3
+type RecordCondition struct {
+	any [][]comparison
+}
+
+// ParseRecordCondition Parse expressions of the form key op value, op being one of == != < <= > >= =~ !~.
+// Values may be quoted with " or ' so they can hold && or ||, a backslash escaping the quote character.
+func ParseRecordCondition(s string) (*RecordCondition, error) {
+	c := &RecordCondition{}
+	var all []comparison
+
+	rest := s
+	for {
+		cmp, tail, err := parseComparison(rest)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %v", s, err)
+		}
+		all = append(all, cmp)
+
+		tail = strings.TrimSpace(tail)
+		switch {
+		case len(tail) == 0:
+			c.any = append(c.any, all)
+			return c, nil
+		case strings.HasPrefix(tail, "&&"):
+		case strings.HasPrefix(tail, "||"):
+			c.any = append(c.any, all)
+			all = nil
+		default:
+			return nil, fmt.Errorf("condition %q: expected && or || before %q", s, tail)
+		}
+		rest = tail[2:]
+	}
+}
+
+// TrimConditionQuotes Drop double quotes wrapping a whole configured condition.  A condition starts with
+// a key, so a leading quote can only be such a wrapper, and a quoted last value keeps its closing quote.
+func TrimConditionQuotes(c string) string {
+	if len(c) > 1 && c[0] == '"' && c[len(c)-1] == '"' {
+		return c[1 : len(c)-1]
+	}
+	return c
+}
+
+// conditionOperatorAt The operator starting at s[i], longest first so <= is not read as <
+func conditionOperatorAt(s string, i int) string {
+	for _, op := range conditionOperators {
+		if strings.HasPrefix(s[i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parseComparison Read one comparison from the front of s, split at its leftmost operator, and return the unread rest
+func parseComparison(s string) (comparison, string, error) {
+	var cmp comparison
+
+	i := 0
+	for ; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") {
+			break
+		}
+		if cmp.operator = conditionOperatorAt(s, i); len(cmp.operator) != 0 {
+			break
+		}
+	}
+	cmp.key = strings.TrimSpace(s[:i])
+	if len(cmp.operator) == 0 {
+		return cmp, "", fmt.Errorf("%q has no operator", strings.TrimSpace(s[:i]))
+	}
+	if len(cmp.key) == 0 {
+		return cmp, "", fmt.Errorf("%s has no key", cmp.operator)
+	}
+
+	rest := strings.TrimLeft(s[i+len(cmp.operator):], " \t")
+	if len(rest) != 0 && (rest[0] == '"' || rest[0] == '\'') {
+		quote := rest[0]
+		var b strings.Builder
+		j := 1
+		for ; j < len(rest) && rest[j] != quote; j++ {
+			if rest[j] == '\\' && j+1 < len(rest) && rest[j+1] == quote {
+				j++
+			}
+			b.WriteByte(rest[j])
+		}
+		if j == len(rest) {
+			return cmp, "", fmt.Errorf("unterminated quote in value of %s", cmp.key)
+		}
+		cmp.value, rest = b.String(), rest[j+1:]
+	} else {
+		j := len(rest)
+		if k := strings.Index(rest, "&&"); k >= 0 {
+			j = k
+		}
+		if k := strings.Index(rest, "||"); k >= 0 && k < j {
+			j = k
+		}
+		cmp.value, rest = strings.TrimSpace(rest[:j]), rest[j:]
+	}
+
+	switch cmp.operator {
+	case "=~", "!~":
+		re, err := regexp.Compile(cmp.value)
+		if err != nil {
+			return cmp, "", fmt.Errorf("%s: %v", cmp.key, err)
+		}
+		cmp.re = re
+	default:
+		if n, err := strconv.ParseFloat(cmp.value, 64); err == nil {
+			cmp.number, cmp.numeric = n, true
+		} else if cmp.operator != "==" && cmp.operator != "!=" {
+			return cmp, "", fmt.Errorf("%s %s needs a number", cmp.key, cmp.operator)
+		}
+	}
+	return cmp, rest, nil
+}
+
+// Eval Evaluate the condition against a value lookup; missing keys never satisfy a comparison
+func (c *RecordCondition) Eval(get func(key string) interface{}) bool {
+	for _, all := range c.any {
+		ok := true
+		for _, cmp := range all {
+			if !cmp.eval(get(cmp.key)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmp comparison) eval(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	s := fmt.Sprintf("%v", v)
+
+	switch cmp.operator {
+	case "=~":
+		return cmp.re.MatchString(s)
+	case "!~":
+		return !cmp.re.MatchString(s)
+	}
+
+	if cmp.numeric {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return cmp.operator == "!="
+		}
+		switch cmp.operator {
+		case "==":
+			return n == cmp.number
+		case "!=":
+			return n != cmp.number
+		case "<":
+			return n < cmp.number
+		case "<=":
+			return n <= cmp.number
+		case ">":
+			return n > cmp.number
+		case ">=":
+			return n >= cmp.number
+		}
+	}
+
+	if cmp.operator == "!=" {
+		return s != cmp.value
+	}
+	return s == cmp.value
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseRecordConditionErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+	}{
+		{"no operator", "status_code"},
+		{"no key", ">= 500"},
+		{"empty term", "a == 1 &&"},
+		{"number expected", "latency > slow"},
+		{"invalid regex", "path =~ (unclosed"},
+		{"unterminated quote", `msg == "open`},
+		{"junk after quoted value", `msg == "a" b`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRecordCondition(tt.condition); err == nil {
+				t.Errorf("ParseRecordCondition(%q) succeeded, want an error", tt.condition)
+			}
+		})
+	}
+}
+
+func TestParseRecordConditionTerms(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		want      [][]comparison
+	}{
+		{
+			name:      "leftmost operator splits the term",
+			condition: "a=~x>=1",
+			want:      [][]comparison{{{key: "a", operator: "=~", value: "x>=1"}}},
+		},
+		{
+			name:      "two character operator wins at the same position",
+			condition: "latency<=0.3",
+			want:      [][]comparison{{{key: "latency", operator: "<=", value: "0.3"}}},
+		},
+		{
+			name:      "single pipe stays in the regex",
+			condition: "path =~ ^/(a|b)$ && code == 200",
+			want:      [][]comparison{{{key: "path", operator: "=~", value: "^/(a|b)$"}, {key: "code", operator: "==", value: "200"}}},
+		},
+		{
+			name:      "quoted value keeps && and ||",
+			condition: `msg =~ "x||y && z" || level == 'err'`,
+			want:      [][]comparison{{{key: "msg", operator: "=~", value: "x||y && z"}}, {{key: "level", operator: "==", value: "err"}}},
+		},
+		{
+			name:      "escaped quote",
+			condition: `msg == "say \"hi\""`,
+			want:      [][]comparison{{{key: "msg", operator: "==", value: `say "hi"`}}},
+		},
+		{
+			name:      "and binds tighter than or",
+			condition: "a == 1 && b == 2 || c == 3",
+			want: [][]comparison{
+				{{key: "a", operator: "==", value: "1"}, {key: "b", operator: "==", value: "2"}},
+				{{key: "c", operator: "==", value: "3"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseRecordCondition(tt.condition)
+			if err != nil {
+				t.Fatalf("ParseRecordCondition(%q): %v", tt.condition, err)
+			}
+			if len(c.any) != len(tt.want) {
+				t.Fatalf("%d alternatives, want %d", len(c.any), len(tt.want))
+			}
+			for i, all := range c.any {
+				if len(all) != len(tt.want[i]) {
+					t.Fatalf("alternative %d has %d terms, want %d", i, len(all), len(tt.want[i]))
+				}
+				for j, cmp := range all {
+					w := tt.want[i][j]
+					if cmp.key != w.key || cmp.operator != w.operator || cmp.value != w.value {
+						t.Errorf("term %d.%d = %s %s %q, want %s %s %q", i, j, cmp.key, cmp.operator, cmp.value, w.key, w.operator, w.value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecordConditionEval(t *testing.T) {
+	record := map[string]interface{}{
+		"status_code": 503,
+		"latency":     "0.1",
+		"path":        "/api/b",
+		"msg":         "x||y",
+	}
+	get := func(key string) interface{} { return record[key] }
+
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{"status_code >= 500", true},
+		{"status_code < 500", false},
+		{"status_code >= 500 && latency > 0.3", false},
+		{"status_code >= 500 && latency > 0.3 || path =~ ^/api/(a|b)$", true},
+		{"path !~ ^/api", false},
+		{"missing == 1", false},
+		{"missing != 1", false},
+		{"latency != slow", true},
+		{"path == /api/b", true},
+		{`msg == "x||y"`, true},
+		{"status_code == 503.0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			c, err := ParseRecordCondition(tt.condition)
+			if err != nil {
+				t.Fatalf("ParseRecordCondition(%q): %v", tt.condition, err)
+			}
+			if got := c.Eval(get); got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimConditionQuotes(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`"status >= 500"`, "status >= 500"},
+		{`msg == "x"`, `msg == "x"`},
+		{`"msg == "x""`, `msg == "x"`},
+		{`"`, `"`},
+	}
+	for _, tt := range tests {
+		if got := TrimConditionQuotes(tt.in); got != tt.want {
+			t.Errorf("TrimConditionQuotes(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
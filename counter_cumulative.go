@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CumulativeTracker Turns a field holding a monotonic source counter into deltas per label set.
+// A value lower than the previous one is treated as a source restart, as Prometheus increase() does.
+type CumulativeTracker struct {
+	labelNames []string
+	last       map[string]float64
+}
+
+func NewCumulativeTracker(labelNames []string) *CumulativeTracker {
+	return &CumulativeTracker{
+		labelNames: labelNames,
+		last:       make(map[string]float64),
+	}
+}
+
+// Delta Return the increase since the previous value seen for the label set.
+// The first value only establishes the baseline, so it never counts towards the total.
+func (c *CumulativeTracker) Delta(labels prometheus.Labels, v float64) float64 {
+	key := labelsKey(labelValues(labels, c.labelNames))
+
+	prev, ok := c.last[key]
+	c.last[key] = v
+
+	switch {
+	case !ok:
+		return 0
+	case v < prev:
+		return v
+	default:
+		return v - prev
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-logfmt/logfmt"
+)
+
+// FieldDecoder Describes a record field holding an embedded JSON or logfmt string
+type FieldDecoder struct {
+	Field  string
+	Format string
+	Prefix string
+}
+
+// SetRecordDecodeField Set context record_decode_field
+// Required: No
+func (d *FieldDecoder) SetRecordDecodeField(f string) {
+	d.Field = ConfigKeyQuoteTrim(f)
+}
+
+// SetRecordDecodeFormat Set context record_decode_format
+// Required with record_decode_field: Yes
+// Values: JSON, Logfmt
+func (d *FieldDecoder) SetRecordDecodeFormat(f string, logger log.Logger) {
+	switch f {
+	case "JSON", "Logfmt":
+		d.Format = f
+	case "":
+		level.Error(logger).Log("msg", "record_decode_format not populated")
+		panic(1)
+	default:
+		level.Error(logger).Log("msg", "Unknown record_decode_format", "format", f)
+		panic(1)
+	}
+}
+
+// SetRecordDecodePrefix Set context record_decode_prefix
+// Required: No
+// Default: <record_decode_field>.
+func (d *FieldDecoder) SetRecordDecodePrefix(p string) {
+	if len(p) != 0 {
+		d.Prefix = ConfigKeyQuoteTrim(p)
+	} else {
+		d.Prefix = d.Field + "."
+	}
+}
+
+func (d *FieldDecoder) IsEnabled() bool {
+	return len(d.Field) != 0
+}
+
+// decode Parse the embedded string into a flat key/value map
+func (d *FieldDecoder) decode(s string) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+
+	switch d.Format {
+	case "JSON":
+		nested := make(map[string]interface{})
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		if err := dec.Decode(&nested); err != nil {
+			return nil, err
+		}
+		flatten(m, "", nested)
+	case "Logfmt":
+		dec := logfmt.NewDecoder(strings.NewReader(s))
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				m[string(dec.Key())] = string(dec.Value())
+			}
+		}
+		if err := dec.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// flatten Copy nested JSON objects into m with their keys joined by dots, Ex. {"a":{"b":1}} as a.b
+func flatten(m map[string]interface{}, prefix string, nested map[string]interface{}) {
+	for k, v := range nested {
+		if obj, ok := v.(map[string]interface{}); ok {
+			flatten(m, prefix+k+".", obj)
+			continue
+		}
+		m[prefix+k] = v
+	}
+}
+
+// RecordFields Wraps a decoded record so that embedded fields are only parsed on demand
+type RecordFields struct {
+	Fields  map[string]interface{}
+	decoder *FieldDecoder
+	logger  log.Logger
+	decoded bool
+}
+
+func NewRecordFields(fields map[string]interface{}, d *FieldDecoder, logger log.Logger) *RecordFields {
+	return &RecordFields{
+		Fields:  fields,
+		decoder: d,
+		logger:  logger,
+	}
+}
+
+// Get Look up a key, decoding the configured field the first time a prefixed key is missing
+func (r *RecordFields) Get(key string) interface{} {
+	if v, ok := r.Fields[key]; ok {
+		return v
+	}
+	if r.decoded || !r.decoder.IsEnabled() || !strings.HasPrefix(key, r.decoder.Prefix) {
+		return nil
+	}
+	r.decoded = true
+
+	raw, ok := r.Fields[r.decoder.Field]
+	if !ok {
+		return nil
+	}
+
+	var s string
+	switch t := raw.(type) {
+	case string:
+		s = t
+	case []byte:
+		s = string(t)
+	default:
+		s = fmt.Sprintf("%v", t)
+	}
+
+	m, err := r.decoder.decode(strings.TrimSpace(s))
+	if err != nil {
+		level.Debug(r.logger).Log("msg", "Unable to decode record field", "field", r.decoder.Field, "format", r.decoder.Format, "err", err)
+		return nil
+	}
+	for k, v := range m {
+		if _, exists := r.Fields[r.decoder.Prefix+k]; !exists {
+			r.Fields[r.decoder.Prefix+k] = v
+		}
+	}
+
+	return r.Fields[key]
+}
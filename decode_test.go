@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestFieldDecoderDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		in      string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "JSON numbers kept exact",
+			format: "JSON",
+			in:     `{"status":503,"latency":0.25,"id":12345678901234567890}`,
+			want:   map[string]interface{}{"status": json.Number("503"), "latency": json.Number("0.25"), "id": json.Number("12345678901234567890")},
+		},
+		{
+			name:   "JSON objects flattened",
+			format: "JSON",
+			in:     `{"http":{"req":{"method":"GET"},"status":200},"ok":true}`,
+			want:   map[string]interface{}{"http.req.method": "GET", "http.status": json.Number("200"), "ok": true},
+		},
+		{
+			name:   "JSON arrays left whole",
+			format: "JSON",
+			in:     `{"tags":["a","b"]}`,
+			want:   map[string]interface{}{"tags": []interface{}{"a", "b"}},
+		},
+		{name: "JSON not an object", format: "JSON", in: `[1,2]`, wantErr: true},
+		{name: "JSON truncated", format: "JSON", in: `{"status":`, wantErr: true},
+		{
+			name:   "logfmt",
+			format: "Logfmt",
+			in:     `level=error msg="disk full" status=503 retry`,
+			want:   map[string]interface{}{"level": "error", "msg": "disk full", "status": "503", "retry": ""},
+		},
+		{name: "logfmt unterminated quote", format: "Logfmt", in: `msg="disk full`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &FieldDecoder{Field: "log", Format: tt.format}
+			got, err := d.decode(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decode(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordFieldsGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		prefix string
+		fields map[string]interface{}
+		key    string
+		want   interface{}
+	}{
+		{
+			name:   "top level field",
+			format: "JSON",
+			fields: map[string]interface{}{"host": "web1", "log": `{"status":200}`},
+			key:    "host",
+			want:   "web1",
+		},
+		{
+			name:   "embedded JSON under the default prefix",
+			format: "JSON",
+			fields: map[string]interface{}{"log": `  {"http":{"status":200}}` + "\n"},
+			key:    "log.http.status",
+			want:   json.Number("200"),
+		},
+		{
+			name:   "embedded logfmt given as bytes",
+			format: "Logfmt",
+			fields: map[string]interface{}{"log": []byte("level=warn")},
+			key:    "log.level",
+			want:   "warn",
+		},
+		{
+			name:   "custom prefix",
+			format: "Logfmt",
+			prefix: "app_",
+			fields: map[string]interface{}{"log": "level=warn"},
+			key:    "app_level",
+			want:   "warn",
+		},
+		{
+			name:   "top level field not overwritten",
+			format: "Logfmt",
+			fields: map[string]interface{}{"log": "level=warn", "log.level": "info"},
+			key:    "log.level",
+			want:   "info",
+		},
+		{
+			name:   "key without the prefix not decoded",
+			format: "Logfmt",
+			fields: map[string]interface{}{"log": "level=warn"},
+			key:    "level",
+		},
+		{
+			name:   "missing decode field",
+			format: "JSON",
+			fields: map[string]interface{}{"host": "web1"},
+			key:    "log.status",
+		},
+		{
+			name:   "undecodable field",
+			format: "JSON",
+			fields: map[string]interface{}{"log": "plain text"},
+			key:    "log.status",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &FieldDecoder{Field: "log", Format: tt.format}
+			d.SetRecordDecodePrefix(tt.prefix)
+			r := NewRecordFields(tt.fields, d, log.NewNopLogger())
+			if got := r.Get(tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Get(%q) = %#v, want %#v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordFieldsDecodesOnce(t *testing.T) {
+	d := &FieldDecoder{Field: "log", Format: "Logfmt", Prefix: "log."}
+	fields := map[string]interface{}{"log": "level=warn"}
+	r := NewRecordFields(fields, d, log.NewNopLogger())
+
+	if got := r.Get("log.missing"); got != nil {
+		t.Errorf("Get(log.missing) = %v, want nil", got)
+	}
+	// Decoded fields are kept, so changing the raw field has no effect
+	fields["log"] = "level=error"
+	if got := r.Get("log.level"); got != "warn" {
+		t.Errorf("Get(log.level) = %v, want warn", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type seenRecord struct {
+	hash uint64
+	seen time.Time
+}
+
+// RecordDeduplicator Skips records whose ID field, or whole content, was already seen within the window.
+// Hashes are held least recently seen first, bounded in number, so a duplicate keeps its hash
+// remembered for another window and the hashes gone quiet longest are the first to go.
+type RecordDeduplicator struct {
+	Key        string
+	Window     time.Duration
+	MaxEntries int
+	seen       map[uint64]*list.Element
+	order      *list.List
+	Hits       prometheus.Counter
+	Misses     prometheus.Counter
+}
+
+// SetRecordDedupWindow Set context record_dedup_window
+// Required: No
+// Note: Enables de-duplication, Ex. 5m
+func (d *RecordDeduplicator) SetRecordDedupWindow(w string, logger log.Logger) {
+	if len(w) != 0 {
+		v, err := time.ParseDuration(w)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "record_dedup_window not a positive duration", "err", err)
+			panic(1)
+		}
+		d.Window = v
+	}
+}
+
+// SetRecordDedupKey Set context record_dedup_key
+// Required: No
+// Note: When unset the whole record and its timestamp are hashed
+func (d *RecordDeduplicator) SetRecordDedupKey(k string) {
+	d.Key = ConfigKeyQuoteTrim(k)
+}
+
+// SetRecordDedupMaxEntries Set context record_dedup_max_entries
+// Required: No
+// Default: 100000
+func (d *RecordDeduplicator) SetRecordDedupMaxEntries(m string, logger log.Logger) {
+	d.MaxEntries = 100000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "record_dedup_max_entries not a positive integer, defaulting to 100000.", "err", err)
+			return
+		}
+		d.MaxEntries = v
+	}
+}
+
+func (d *RecordDeduplicator) IsEnabled() bool {
+	return d.Window > 0
+}
+
+// NewMetric Create the hit and miss self-metrics and the empty table
+func (d *RecordDeduplicator) NewMetric(p *PluginContext) {
+	d.seen = make(map[uint64]*list.Element)
+	d.order = list.New()
+	d.Hits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        p.Name + "_dedup_hits_total",
+		Help:        "Records skipped by " + p.Name + " as already seen within the de-duplication window",
+		ConstLabels: p.ConstantLabels,
+	})
+	d.Misses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        p.Name + "_dedup_misses_total",
+		Help:        "Records passed on to " + p.Name + " by the de-duplication window",
+		ConstLabels: p.ConstantLabels,
+	})
+}
+
+// Seen Report whether the record is a duplicate, remembering it as just seen either way.
+// Records without the ID field are never treated as duplicates.
+func (d *RecordDeduplicator) Seen(records map[string]interface{}, fields *RecordFields, ts time.Time, logger log.Logger) bool {
+	var h uint64
+	if len(d.Key) != 0 {
+		id := fields.Get(d.Key)
+		if id == nil {
+			d.Misses.Inc()
+			return false
+		}
+		h = xxhash.Sum64String(fmt.Sprintf("%v", id))
+	} else {
+		// encoding/json sorts map keys, so equal records always encode the same
+		b, err := json.Marshal(records)
+		if err != nil {
+			level.Error(logger).Log("msg", "Unable to hash record for de-duplication", "err", err)
+			d.Misses.Inc()
+			return false
+		}
+		b = strconv.AppendInt(b, ts.UnixNano(), 10)
+		h = xxhash.Sum64(b)
+	}
+
+	now := time.Now()
+	for e := d.order.Front(); e != nil && now.Sub(e.Value.(*seenRecord).seen) >= d.Window; e = d.order.Front() {
+		d.forget(e)
+	}
+
+	if e, ok := d.seen[h]; ok {
+		e.Value.(*seenRecord).seen = now
+		d.order.MoveToBack(e)
+		d.Hits.Inc()
+		return true
+	}
+
+	// Only a new hash needs room, a hit must not push out another record
+	for d.order.Len() >= d.MaxEntries {
+		d.forget(d.order.Front())
+	}
+	d.seen[h] = d.order.PushBack(&seenRecord{hash: h, seen: now})
+	d.Misses.Inc()
+	return false
+}
+
+func (d *RecordDeduplicator) forget(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.seen, e.Value.(*seenRecord).hash)
+}
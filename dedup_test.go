@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newRecordDeduplicator(key string, maxEntries int) *RecordDeduplicator {
+	p := &PluginContext{}
+	p.Name = "test"
+	d := &RecordDeduplicator{Key: key, Window: time.Minute, MaxEntries: maxEntries}
+	d.NewMetric(p)
+	return d
+}
+
+func TestRecordDeduplicatorSeen(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := func(id interface{}, msg string) map[string]interface{} {
+		r := map[string]interface{}{"msg": msg}
+		if id != nil {
+			r["event_id"] = id
+		}
+		return r
+	}
+
+	type delivery struct {
+		record map[string]interface{}
+		ts     time.Time
+		want   bool
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		maxEntries int
+		deliveries []delivery
+	}{
+		{
+			name: "same ID, different content",
+			key:  "event_id",
+			deliveries: []delivery{
+				{record("a1", "first"), ts, false},
+				{record("a1", "retried"), ts.Add(time.Second), true},
+				{record("a2", "first"), ts, false},
+			},
+		},
+		{
+			name: "ID compared as text",
+			key:  "event_id",
+			deliveries: []delivery{
+				{record(int64(7), "x"), ts, false},
+				{record("7", "x"), ts, true},
+			},
+		},
+		{
+			name: "missing ID always counted",
+			key:  "event_id",
+			deliveries: []delivery{
+				{record(nil, "x"), ts, false},
+				{record(nil, "x"), ts, false},
+			},
+		},
+		{
+			name: "whole record and timestamp hashed without a key",
+			deliveries: []delivery{
+				{record("a1", "x"), ts, false},
+				{record("a1", "x"), ts, true},
+				{record("a1", "x"), ts.Add(time.Second), false},
+				{record("a1", "y"), ts, false},
+			},
+		},
+		{
+			name:       "least recently seen evicted at max entries",
+			key:        "event_id",
+			maxEntries: 2,
+			deliveries: []delivery{
+				{record("a", ""), ts, false},
+				{record("b", ""), ts, false},
+				// The hit makes b the least recently seen
+				{record("a", ""), ts, true},
+				{record("c", ""), ts, false},
+				{record("a", ""), ts, true},
+				{record("b", ""), ts, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxEntries := tt.maxEntries
+			if maxEntries == 0 {
+				maxEntries = 100
+			}
+			d := newRecordDeduplicator(tt.key, maxEntries)
+
+			var hits, misses float64
+			for i, dl := range tt.deliveries {
+				fields := NewRecordFields(dl.record, &FieldDecoder{}, log.NewNopLogger())
+				if got := d.Seen(dl.record, fields, dl.ts, log.NewNopLogger()); got != dl.want {
+					t.Errorf("delivery %d: Seen = %v, want %v", i, got, dl.want)
+				}
+				if dl.want {
+					hits++
+				} else {
+					misses++
+				}
+			}
+			if got := testutil.ToFloat64(d.Hits); got != hits {
+				t.Errorf("hits = %v, want %v", got, hits)
+			}
+			if got := testutil.ToFloat64(d.Misses); got != misses {
+				t.Errorf("misses = %v, want %v", got, misses)
+			}
+		})
+	}
+}
+
+func TestRecordDeduplicatorWindow(t *testing.T) {
+	d := newRecordDeduplicator("event_id", 100)
+	r := map[string]interface{}{"event_id": "a1"}
+	fields := NewRecordFields(r, &FieldDecoder{}, log.NewNopLogger())
+
+	if d.Seen(r, fields, time.Now(), log.NewNopLogger()) {
+		t.Fatal("first delivery reported as a duplicate")
+	}
+	d.order.Front().Value.(*seenRecord).seen = time.Now().Add(-2 * time.Minute)
+
+	if d.Seen(r, fields, time.Now(), log.NewNopLogger()) {
+		t.Error("delivery after the window reported as a duplicate")
+	}
+	if got := d.order.Len(); got != 1 {
+		t.Errorf("%d hashes remembered, want 1 once the expired one is dropped", got)
+	}
+}
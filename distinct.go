@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// distinctSlices Number of sub-windows making up the rolling window
+const distinctSlices = 5
+
+// HyperLogLog Fixed precision cardinality sketch
+type HyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+func NewHyperLogLog(p uint8) *HyperLogLog {
+	return &HyperLogLog{p: p, registers: make([]uint8, 1<<p)}
+}
+
+func (h *HyperLogLog) Add(hash uint64) {
+	idx := hash >> (64 - h.p)
+	// Rank of the first set bit in the remaining bits, bounded so an all-zero tail stays in range
+	rank := uint8(bits.LeadingZeros64(hash<<h.p|1<<(h.p-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Merge Fold another sketch of the same precision into this one
+func (h *HyperLogLog) Merge(o *HyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *HyperLogLog) Reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+}
+
+// Estimate Standard HyperLogLog estimate with linear counting for small cardinalities
+func (h *HyperLogLog) Estimate() float64 {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return math.Round(estimate)
+}
+
+type distinctSlice struct {
+	epoch int64
+	hll   *HyperLogLog
+}
+
+type distinctSeries struct {
+	labelValues []string
+	slices      [distinctSlices]distinctSlice
+}
+
+// DistinctCollector Gauge exporting the estimated number of distinct values per label set over a rolling window
+type DistinctCollector struct {
+	mu         sync.Mutex
+	desc       *prometheus.Desc
+	labelNames []string
+	precision  uint8
+	slice      time.Duration
+	series     map[string]*distinctSeries
+}
+
+type FBDistinct struct {
+	Handle *DistinctCollector
+}
+
+func (d *FBDistinct) NewMetric(p *PluginContext) {
+	d.Handle = &DistinctCollector{
+		desc:       prometheus.NewDesc(p.Name, p.Help, p.VariableLabels, p.ConstantLabels),
+		labelNames: p.VariableLabels,
+		precision:  p.Distinct.Precision,
+		slice:      p.Distinct.Window / distinctSlices,
+		series:     make(map[string]*distinctSeries),
+	}
+}
+
+func (d *DistinctCollector) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(d.slice)
+}
+
+// Observe Add a value to the sketch of the current sub-window for the label set
+func (d *DistinctCollector) Observe(labels prometheus.Labels, value string) {
+	values := labelValues(labels, d.labelNames)
+	key := labelsKey(values)
+	epoch := d.epoch(time.Now())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.series[key]
+	if !ok {
+		s = &distinctSeries{labelValues: values}
+		d.series[key] = s
+	}
+
+	sl := &s.slices[epoch%distinctSlices]
+	if sl.hll == nil {
+		sl.hll = NewHyperLogLog(d.precision)
+	} else if sl.epoch != epoch {
+		sl.hll.Reset()
+	}
+	sl.epoch = epoch
+	sl.hll.Add(xxhash.Sum64String(value))
+}
+
+func (d *DistinctCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.desc
+}
+
+func (d *DistinctCollector) Collect(ch chan<- prometheus.Metric) {
+	epoch := d.epoch(time.Now())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, s := range d.series {
+		merged := NewHyperLogLog(d.precision)
+		live := false
+		for _, sl := range s.slices {
+			if sl.hll != nil && epoch-sl.epoch < distinctSlices {
+				merged.Merge(sl.hll)
+				live = true
+			}
+		}
+		if !live {
+			// Nothing seen for a whole window, forget the label set
+			delete(d.series, key)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, merged.Estimate(), s.labelValues...)
+	}
+}
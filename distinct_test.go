@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision uint8
+		distinct  int
+		repeats   int
+	}{
+		{"empty", 14, 0, 1},
+		{"single value", 14, 1, 1},
+		{"small set repeated", 14, 100, 5},
+		{"linear counting range", 14, 10000, 1},
+		{"large set", 14, 200000, 1},
+		{"low precision", 10, 50000, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHyperLogLog(tt.precision)
+			for r := 0; r < tt.repeats; r++ {
+				for i := 0; i < tt.distinct; i++ {
+					h.Add(xxhash.Sum64String(fmt.Sprintf("value-%d", i)))
+				}
+			}
+
+			// Four standard errors of 1.04/sqrt(m), a bound a correct sketch essentially never exceeds
+			tolerance := 4 * 1.04 / math.Sqrt(float64(uint64(1)<<tt.precision)) * float64(tt.distinct)
+			if got := h.Estimate(); math.Abs(got-float64(tt.distinct)) > math.Max(tolerance, 1) {
+				t.Errorf("Estimate() = %v, want %d within %.0f", got, tt.distinct, tolerance)
+			}
+		})
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, b, union := NewHyperLogLog(12), NewHyperLogLog(12), NewHyperLogLog(12)
+	for i := 0; i < 3000; i++ {
+		hash := xxhash.Sum64String(fmt.Sprintf("value-%d", i))
+		if i < 2000 {
+			a.Add(hash)
+		}
+		if i >= 1000 {
+			b.Add(hash)
+		}
+		union.Add(hash)
+	}
+
+	a.Merge(b)
+	if got, want := a.Estimate(), union.Estimate(); got != want {
+		t.Errorf("merged Estimate() = %v, want the union estimate %v", got, want)
+	}
+
+	a.Reset()
+	if got := a.Estimate(); got != 0 {
+		t.Errorf("Estimate() after Reset() = %v, want 0", got)
+	}
+}
+
+func TestDistinctCollector(t *testing.T) {
+	p := &PluginContext{}
+	p.Name = "test_distinct"
+	p.Help = "test"
+	p.VariableLabels = []string{"route"}
+	p.Distinct.Precision = 14
+	p.Distinct.Window = time.Hour
+
+	var d FBDistinct
+	d.NewMetric(p)
+
+	tests := []struct {
+		route  string
+		values []string
+		want   float64
+	}{
+		{"/a", []string{"alice", "bob", "alice", "carol"}, 3},
+		{"/b", []string{"dave", "dave"}, 1},
+	}
+	for _, tt := range tests {
+		for _, v := range tt.values {
+			d.Handle.Observe(prometheus.Labels{"route": tt.route}, v)
+		}
+	}
+
+	if got := testutil.CollectAndCount(d.Handle); got != len(tests) {
+		t.Fatalf("collected %d series, want %d", got, len(tests))
+	}
+	for _, tt := range tests {
+		d.Handle.mu.Lock()
+		s := d.Handle.series[labelsKey([]string{tt.route})]
+		d.Handle.mu.Unlock()
+
+		merged := NewHyperLogLog(p.Distinct.Precision)
+		for _, sl := range s.slices {
+			if sl.hll != nil {
+				merged.Merge(sl.hll)
+			}
+		}
+		if got := merged.Estimate(); got != tt.want {
+			t.Errorf("route %s estimate = %v, want %v", tt.route, got, tt.want)
+		}
+	}
+}
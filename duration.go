@@ -0,0 +1,122 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DurationCorrelator Pairs start and end records sharing an ID and reports the elapsed record time.
+// Pending starts are held in a bounded map and evicted after a TTL, counting as abandoned.
+type DurationCorrelator struct {
+	mu         sync.Mutex
+	startValue string
+	endValue   string
+	ttl        time.Duration
+	maxPending int
+	pending    map[string]*list.Element
+	order      *list.List
+	Abandoned  *prometheus.CounterVec
+}
+
+type pendingStart struct {
+	id     string
+	start  time.Time
+	seen   time.Time
+	labels prometheus.Labels
+}
+
+type FBCorrelation struct {
+	Handle *DurationCorrelator
+}
+
+func (c *FBCorrelation) NewMetric(p *PluginContext) {
+	c.Handle = &DurationCorrelator{
+		startValue: p.Correlation.StartValue,
+		endValue:   p.Correlation.EndValue,
+		ttl:        p.Correlation.TTL,
+		maxPending: p.Correlation.MaxPending,
+		pending:    make(map[string]*list.Element),
+		order:      list.New(),
+		Abandoned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        p.Name + "_abandoned_total",
+			Help:        "Start records for " + p.Name + " evicted before a matching end record arrived",
+			ConstLabels: p.ConstantLabels,
+		}, p.VariableLabels),
+	}
+}
+
+// Track Feed a record into the correlator.  A start is remembered, an end returns the elapsed time
+// since its start.  Any other event value, or an end without a known start, is ignored.
+func (d *DurationCorrelator) Track(event, id string, ts time.Time, labels prometheus.Labels) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.evict(now)
+
+	switch event {
+	case d.startValue:
+		if e, ok := d.pending[id]; ok {
+			// A repeated start abandons the earlier attempt
+			d.remove(e, true)
+		}
+		for d.maxPending > 0 && d.order.Len() >= d.maxPending {
+			d.remove(d.order.Front(), true)
+		}
+		d.pending[id] = d.order.PushBack(&pendingStart{id: id, start: ts, seen: now, labels: labels})
+	case d.endValue:
+		e, ok := d.pending[id]
+		if !ok {
+			return 0, false
+		}
+		start := e.Value.(*pendingStart).start
+		d.remove(e, false)
+
+		elapsed := ts.Sub(start)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		return elapsed, true
+	}
+
+	return 0, false
+}
+
+// evict Drop starts older than the TTL.  Entries are kept in arrival order so only the front needs checking.
+func (d *DurationCorrelator) evict(now time.Time) {
+	if d.ttl <= 0 {
+		return
+	}
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		if now.Sub(e.Value.(*pendingStart).seen) < d.ttl {
+			return
+		}
+		d.remove(e, true)
+	}
+}
+
+func (d *DurationCorrelator) Describe(ch chan<- *prometheus.Desc) {
+	d.Abandoned.Describe(ch)
+}
+
+// Collect Evicts expired starts first, so abandoned_total keeps moving after traffic stops
+func (d *DurationCorrelator) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	d.evict(time.Now())
+	d.mu.Unlock()
+
+	d.Abandoned.Collect(ch)
+}
+
+func (d *DurationCorrelator) remove(e *list.Element, abandoned bool) {
+	p := e.Value.(*pendingStart)
+	d.order.Remove(e)
+	delete(d.pending, p.id)
+
+	if abandoned {
+		d.Abandoned.With(p.labels).Inc()
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newDurationCorrelator(ttl time.Duration, maxPending int) *DurationCorrelator {
+	p := &PluginContext{}
+	p.Name = "test_duration"
+	p.VariableLabels = []string{"job"}
+	p.Correlation.StartValue = "start"
+	p.Correlation.EndValue = "end"
+	p.Correlation.TTL = ttl
+	p.Correlation.MaxPending = maxPending
+	var c FBCorrelation
+	c.NewMetric(p)
+	return c.Handle
+}
+
+func TestDurationCorrelatorTrack(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type record struct {
+		event string
+		id    string
+		after time.Duration
+		// want Elapsed time reported, negative when none is
+		want time.Duration
+	}
+
+	tests := []struct {
+		name       string
+		maxPending int
+		records    []record
+		abandoned  float64
+	}{
+		{
+			name:    "start then end",
+			records: []record{{"start", "a", 0, -1}, {"end", "a", 90 * time.Second, 90 * time.Second}},
+		},
+		{
+			name: "interleaved IDs",
+			records: []record{
+				{"start", "a", 0, -1},
+				{"start", "b", time.Second, -1},
+				{"end", "b", 3 * time.Second, 2 * time.Second},
+				{"end", "a", 5 * time.Second, 5 * time.Second},
+			},
+		},
+		{
+			name:    "end without a start",
+			records: []record{{"end", "a", 0, -1}},
+		},
+		{
+			name:    "end matched once",
+			records: []record{{"start", "a", 0, -1}, {"end", "a", time.Second, time.Second}, {"end", "a", 2 * time.Second, -1}},
+		},
+		{
+			name:    "other events ignored",
+			records: []record{{"start", "a", 0, -1}, {"progress", "a", time.Second, -1}, {"end", "a", 2 * time.Second, 2 * time.Second}},
+		},
+		{
+			name:    "end stamped before its start clamped to zero",
+			records: []record{{"start", "a", time.Minute, -1}, {"end", "a", 0, 0}},
+		},
+		{
+			name:      "repeated start abandons the earlier one",
+			records:   []record{{"start", "a", 0, -1}, {"start", "a", time.Minute, -1}, {"end", "a", 2 * time.Minute, time.Minute}},
+			abandoned: 1,
+		},
+		{
+			name:       "oldest start evicted at max pending",
+			maxPending: 2,
+			records: []record{
+				{"start", "a", 0, -1},
+				{"start", "b", 0, -1},
+				{"start", "c", 0, -1},
+				{"end", "a", time.Second, -1},
+				{"end", "c", time.Second, time.Second},
+			},
+			abandoned: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDurationCorrelator(time.Hour, tt.maxPending)
+			labels := prometheus.Labels{"job": "export"}
+			for i, r := range tt.records {
+				elapsed, ok := d.Track(r.event, r.id, t0.Add(r.after), labels)
+				if ok != (r.want >= 0) || (ok && elapsed != r.want) {
+					t.Errorf("record %d: Track = %v, %v, want %v", i, elapsed, ok, r.want)
+				}
+			}
+			if got := testutil.ToFloat64(d.Abandoned.With(labels)); got != tt.abandoned {
+				t.Errorf("abandoned = %v, want %v", got, tt.abandoned)
+			}
+		})
+	}
+}
+
+func TestDurationCorrelatorTTL(t *testing.T) {
+	d := newDurationCorrelator(time.Minute, 0)
+	labels := prometheus.Labels{"job": "export"}
+	t0 := time.Now()
+
+	d.Track("start", "a", t0, labels)
+	d.Track("start", "b", t0, labels)
+	d.pending["a"].Value.(*pendingStart).seen = time.Now().Add(-2 * time.Minute)
+
+	// Collect evicts without any new record arriving
+	if got := testutil.ToFloat64(d); got != 1 {
+		t.Errorf("abandoned = %v, want 1 after the TTL", got)
+	}
+	if _, ok := d.Track("end", "a", t0.Add(time.Second), labels); ok {
+		t.Error("end matched a start evicted by the TTL")
+	}
+	if _, ok := d.Track("end", "b", t0.Add(time.Second), labels); !ok {
+		t.Error("end did not match a start within the TTL")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of metric a DynamicMetrics family can hold
+const (
+	DynamicCounter = iota
+	DynamicGauge
+	DynamicHistogram
+)
+
+// errSeriesLimit A new series was refused because a cardinality cap is reached
+var errSeriesLimit = errors.New("series limit reached")
+
+type dynamicSeries struct {
+	labels  prometheus.Labels
+	value   float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+type dynamicFamily struct {
+	kind   int
+	series map[string]*dynamicSeries
+}
+
+// DynamicMetrics Counters, gauges and histograms created on the fly from names found in records rather
+// than configured up front.  Each series carries its own label names, so the collector is unchecked and
+// describes nothing.  The number of series is capped overall and per metric name.
+type DynamicMetrics struct {
+	mu           sync.Mutex
+	help         string
+	buckets      []float64
+	maxSeries    int
+	maxPerFamily int
+	seriesCount  int
+	families     map[string]*dynamicFamily
+}
+
+// NewDynamicMetrics A cap of 0 is unlimited
+func NewDynamicMetrics(help string, buckets []float64, maxSeries, maxPerFamily int) *DynamicMetrics {
+	return &DynamicMetrics{
+		help:         help,
+		buckets:      buckets,
+		maxSeries:    maxSeries,
+		maxPerFamily: maxPerFamily,
+		families:     make(map[string]*dynamicFamily),
+	}
+}
+
+// series Find or create the series, the caller holds the lock
+func (d *DynamicMetrics) series(name string, kind int, labels prometheus.Labels) (*dynamicSeries, error) {
+	f, ok := d.families[name]
+	if ok && f.kind != kind {
+		return nil, fmt.Errorf("metric %s already exists with another type", name)
+	}
+
+	key := dynamicLabelsKey(labels)
+	if ok {
+		if s, found := f.series[key]; found {
+			return s, nil
+		}
+	}
+
+	if (d.maxSeries > 0 && d.seriesCount >= d.maxSeries) || (ok && d.maxPerFamily > 0 && len(f.series) >= d.maxPerFamily) {
+		return nil, errSeriesLimit
+	}
+	if !ok {
+		f = &dynamicFamily{kind: kind, series: make(map[string]*dynamicSeries)}
+		d.families[name] = f
+	}
+	s := &dynamicSeries{labels: labels}
+	if kind == DynamicHistogram {
+		s.buckets = make([]uint64, len(d.buckets))
+	}
+	f.series[key] = s
+	d.seriesCount++
+	return s, nil
+}
+
+// Add Add v to a counter or gauge
+func (d *DynamicMetrics) Add(name string, kind int, labels prometheus.Labels, v float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, err := d.series(name, kind, labels)
+	if err != nil {
+		return err
+	}
+	s.value += v
+	return nil
+}
+
+// Set Set a gauge to v
+func (d *DynamicMetrics) Set(name string, labels prometheus.Labels, v float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, err := d.series(name, DynamicGauge, labels)
+	if err != nil {
+		return err
+	}
+	s.value = v
+	return nil
+}
+
+// Observe Add n observations of v to a histogram
+func (d *DynamicMetrics) Observe(name string, labels prometheus.Labels, v float64, n uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, err := d.series(name, DynamicHistogram, labels)
+	if err != nil {
+		return err
+	}
+	for i, ub := range d.buckets {
+		if v <= ub {
+			s.buckets[i] += n
+		}
+	}
+	s.count += n
+	s.sum += v * float64(n)
+	return nil
+}
+
+func dynamicLabelsKey(labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, n := range names {
+		b.WriteString(n + "\xff" + labels[n] + "\xff")
+	}
+	return b.String()
+}
+
+// Describe Sends nothing, making the collector unchecked as its series differ in label names
+func (d *DynamicMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (d *DynamicMetrics) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, f := range d.families {
+		for _, s := range f.series {
+			desc := prometheus.NewDesc(name, d.help, nil, s.labels)
+			var m prometheus.Metric
+			var err error
+			switch f.kind {
+			case DynamicCounter:
+				m, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, s.value)
+			case DynamicGauge:
+				m, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, s.value)
+			case DynamicHistogram:
+				buckets := make(map[float64]uint64, len(d.buckets))
+				for i, ub := range d.buckets {
+					buckets[ub] = s.buckets[i]
+				}
+				m, err = prometheus.NewConstHistogram(desc, s.count, s.sum, buckets)
+			}
+			// Label names and values come from records, skip a series the client library refuses
+			// rather than panic inside the scrape
+			if err != nil {
+				continue
+			}
+			ch <- m
+		}
+	}
+}
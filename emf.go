@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// emfDirective The _aws.CloudWatchMetrics entries of an Embedded Metric Format record
+type emfDirective struct {
+	Namespace  string
+	Dimensions [][]string
+	Metrics    []struct {
+		Name string
+		Unit string
+	}
+}
+
+// emfUnits CloudWatch units converted to Prometheus base units: name suffix and scale
+var emfUnits = map[string]struct {
+	suffix string
+	scale  float64
+}{
+	"Seconds":      {"_seconds", 1},
+	"Milliseconds": {"_seconds", 1e-3},
+	"Microseconds": {"_seconds", 1e-6},
+	"Bytes":        {"_bytes", 1},
+	"Kilobytes":    {"_bytes", 1 << 10},
+	"Megabytes":    {"_bytes", 1 << 20},
+	"Gigabytes":    {"_bytes", 1 << 30},
+	"Terabytes":    {"_bytes", 1 << 40},
+	"Percent":      {"_percent", 1},
+	"Count":        {"_total", 1},
+}
+
+// snakeCase Prometheus style name for a CloudWatch name, Ex. MyApp/ProcessedItems into my_app_processed_items
+func snakeCase(s string) string {
+	var b strings.Builder
+	prev := '_'
+	for i, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			// Break before an upper case letter that starts a word, keeping acronyms together
+			next := i+1 < len(s) && unicode.IsLower(rune(s[i+1]))
+			if prev != '_' && (unicode.IsLower(prev) || unicode.IsDigit(prev) || next) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			prev = r
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			prev = r
+		default:
+			if prev != '_' {
+				b.WriteRune('_')
+			}
+			prev = '_'
+		}
+	}
+	return escapeMetricName(strings.Trim(b.String(), "_"))
+}
+
+// EMFCollector Metrics created from the names, units and dimensions CloudWatch Embedded Metric Format
+// records declare.  Count units become Counters, time units Histograms in seconds, anything else Gauges.
+type EMFCollector struct {
+	constLabels prometheus.Labels
+	metrics     *DynamicMetrics
+	Dropped     prometheus.Counter
+	Errors      prometheus.Counter
+}
+
+type FBEMF struct {
+	Handle *EMFCollector
+}
+
+func (e *FBEMF) NewMetric(p *PluginContext) {
+	e.Handle = &EMFCollector{
+		constLabels: p.ConstantLabels,
+		metrics:     NewDynamicMetrics("Metric ingested from CloudWatch Embedded Metric Format records", p.EMF.Buckets, p.EMF.MaxSeries, p.EMF.MaxSeriesPerMetric),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.Name + "_dropped_series_total",
+			Help:        "EMF series dropped because " + p.Name + " reached a cardinality cap",
+			ConstLabels: p.ConstantLabels,
+		}),
+		Errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.Name + "_parse_errors_total",
+			Help:        "EMF records or metric values " + p.Name + " could not use",
+			ConstLabels: p.ConstantLabels,
+		}),
+	}
+}
+
+// ParseEMFRecord Decode a JSON string holding an EMF record
+func ParseEMFRecord(s string) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Ingest Create or update the metrics an EMF record declares.  Records without _aws.CloudWatchMetrics are
+// not EMF and are skipped, reported by the false return.
+func (e *EMFCollector) Ingest(record map[string]interface{}, labels prometheus.Labels) (bool, error) {
+	aws, ok := record["_aws"].(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	raw, ok := aws["CloudWatchMetrics"]
+	if !ok {
+		return false, nil
+	}
+
+	// Round trip through JSON so the directive decodes the same from msgpack and JSON records
+	b, err := json.Marshal(raw)
+	if err != nil {
+		e.Errors.Inc()
+		return true, err
+	}
+	var directives []emfDirective
+	if err := json.Unmarshal(b, &directives); err != nil {
+		e.Errors.Inc()
+		return true, fmt.Errorf("_aws.CloudWatchMetrics: %v", err)
+	}
+
+	var errs []string
+	for _, d := range directives {
+		for _, dims := range d.Dimensions {
+			series, err := e.dimensionLabels(record, dims, labels)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			for _, m := range d.Metrics {
+				if err := e.observe(record, d.Namespace, m.Name, m.Unit, series); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+		}
+		if len(d.Dimensions) == 0 {
+			for _, m := range d.Metrics {
+				if err := e.observe(record, d.Namespace, m.Name, m.Unit, e.dimensionless(labels)); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return true, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return true, nil
+}
+
+func (e *EMFCollector) dimensionless(labels prometheus.Labels) prometheus.Labels {
+	series := prometheus.Labels{}
+	for k, v := range labels {
+		series[k] = v
+	}
+	for k, v := range e.constLabels {
+		series[k] = v
+	}
+	return series
+}
+
+// dimensionLabels Labels of one dimension set, an error when the record lacks one of its dimensions
+// or a dimension name makes no valid label name
+func (e *EMFCollector) dimensionLabels(record map[string]interface{}, dims []string, labels prometheus.Labels) (prometheus.Labels, error) {
+	series := prometheus.Labels{}
+	for _, d := range dims {
+		v, ok := record[d]
+		if !ok {
+			return nil, fmt.Errorf("dimension set %v not all present", dims)
+		}
+		name := snakeCase(d)
+		if !validLabelName(name) {
+			e.Errors.Inc()
+			return nil, fmt.Errorf("dimension %q is not a valid label name", d)
+		}
+		series[name] = fmt.Sprintf("%v", v)
+	}
+	for k, v := range e.dimensionless(labels) {
+		series[k] = v
+	}
+	return series, nil
+}
+
+// validLabelName Label names the client library accepts and the push leaves alone, reserved __ names
+// and the job label the push sets itself excluded
+func validLabelName(name string) bool {
+	return model.LabelName(name).IsValid() && !strings.HasPrefix(name, "__") && !pushGroupingLabel(name)
+}
+
+// observe Apply the value, or every value of an array, of one declared metric
+func (e *EMFCollector) observe(record map[string]interface{}, namespace, name, unit string, labels prometheus.Labels) error {
+	raw, ok := record[name]
+	if !ok {
+		e.Errors.Inc()
+		return fmt.Errorf("metric %s declared but not present", name)
+	}
+	values, ok := raw.([]interface{})
+	if !ok {
+		values = []interface{}{raw}
+	}
+
+	u, ok := emfUnits[unit]
+	if !ok {
+		u.scale = 1
+	}
+	promName := snakeCase(name)
+	if len(namespace) != 0 {
+		promName = snakeCase(namespace) + "_" + promName
+	}
+	promName += u.suffix
+
+	for _, raw := range values {
+		v, err := strconv.ParseFloat(fmt.Sprintf("%v", raw), 64)
+		if err != nil {
+			e.Errors.Inc()
+			return fmt.Errorf("metric %s value %v is not a number", name, raw)
+		}
+		v *= u.scale
+
+		switch u.suffix {
+		case "_total":
+			err = e.metrics.Add(promName, DynamicCounter, labels, v)
+		case "_seconds":
+			err = e.metrics.Observe(promName, labels, v, 1)
+		default:
+			err = e.metrics.Set(promName, labels, v)
+		}
+		if err == errSeriesLimit {
+			e.Dropped.Inc()
+			return nil
+		}
+		if err != nil {
+			e.Errors.Inc()
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EMFCollector) Describe(ch chan<- *prometheus.Desc) {
+	e.metrics.Describe(ch)
+}
+
+func (e *EMFCollector) Collect(ch chan<- prometheus.Metric) {
+	e.metrics.Collect(ch)
+}
+
+// CloudWatch limits of a single EMF directive
+const (
+	emfMaxDimensions = 30
+	emfMaxMetrics    = 100
+)
+
+// EMFWriter Writes the plugin's metrics as CloudWatch Embedded Metric Format JSON lines on a ticker,
+// one record per label set, so the CloudWatch agent or Lambda runtime can pick them up.  CloudWatch
+// sums the values it receives, so counters are written as their increase since the previous line.
+type EMFWriter struct {
+	mu        sync.Mutex
+	namespace string
+	interval  time.Duration
+	out       io.Writer
+	file      *os.File
+	closed    bool
+	last      map[string]float64
+	gatherer  prometheus.Gatherer
+	logger    log.Logger
+	stop      chan struct{}
+}
+
+func NewEMFWriter(p *PluginContext, gatherer prometheus.Gatherer) (*EMFWriter, error) {
+	w := &EMFWriter{
+		namespace: p.EMFNamespace,
+		interval:  p.EMFInterval,
+		out:       os.Stdout,
+		last:      make(map[string]float64),
+		gatherer:  gatherer,
+		logger:    p.Logger,
+		stop:      make(chan struct{}),
+	}
+	if p.EMFOutput != "stdout" {
+		f, err := os.OpenFile(p.EMFOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w.out = f
+		w.file = f
+	}
+	return w, nil
+}
+
+func (w *EMFWriter) Run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := w.write(now); err != nil {
+				level.Error(w.logger).Log("msg", "Could not write EMF output", "err", err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Close Stop Run and close the output file, waiting for a write in progress
+func (w *EMFWriter) Close() error {
+	close(w.stop)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file != nil {
+		return w.file.Close()
+	}
+	return nil
+}
+
+// emfUnit CloudWatch unit guessed from the Prometheus naming conventions
+func emfUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_seconds"), strings.HasSuffix(name, "_seconds_sum"):
+		return "Seconds"
+	case strings.HasSuffix(name, "_bytes"), strings.HasSuffix(name, "_bytes_sum"):
+		return "Bytes"
+	case strings.HasSuffix(name, "_percent"):
+		return "Percent"
+	case strings.HasSuffix(name, "_total"), strings.HasSuffix(name, "_count"):
+		return "Count"
+	}
+	return "None"
+}
+
+func (w *EMFWriter) write(now time.Time) error {
+	samples, err := GatherSamples(w.gatherer)
+	if err != nil {
+		return err
+	}
+
+	// Group the samples sharing a label set into one record
+	type group struct {
+		labels  map[string]string
+		samples []Sample
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _, s := range samples {
+		if strings.HasSuffix(s.Name, "_bucket") || math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			// Buckets have no EMF equivalent, _sum and _count carry the histogram.  JSON has no NaN or Inf.
+			continue
+		}
+		key := Sample{Labels: s.Labels}.key()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: s.Labels}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.samples = append(g.samples, s)
+	}
+	sort.Strings(keys)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+
+	// Cumulative samples are written as their increase since the previous write.  A value below
+	// the previous one is a reset, and the whole value is the increase.
+	last := make(map[string]float64, len(w.last))
+	for _, g := range groups {
+		for i, s := range g.samples {
+			if !s.Cumulative {
+				continue
+			}
+			key := s.key()
+			last[key] = s.Value
+			if prev, ok := w.last[key]; ok && s.Value >= prev {
+				g.samples[i].Value = s.Value - prev
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w.out)
+	for _, key := range keys {
+		g := groups[key]
+
+		dims := make([]string, 0, len(g.labels))
+		for n := range g.labels {
+			dims = append(dims, n)
+		}
+		sort.Strings(dims)
+		if len(dims) > emfMaxDimensions {
+			level.Warn(w.logger).Log("msg", "EMF output keeps the first 30 dimensions", "dimensions", len(dims))
+			dims = dims[:emfMaxDimensions]
+		}
+
+		for start := 0; start < len(g.samples); start += emfMaxMetrics {
+			end := start + emfMaxMetrics
+			if end > len(g.samples) {
+				end = len(g.samples)
+			}
+
+			record := make(map[string]interface{}, len(g.labels)+end-start+1)
+			for n, v := range g.labels {
+				record[n] = v
+			}
+			metrics := make([]map[string]string, 0, end-start)
+			for _, s := range g.samples[start:end] {
+				record[s.Name] = s.Value
+				metrics = append(metrics, map[string]string{"Name": s.Name, "Unit": emfUnit(s.Name)})
+			}
+			record["_aws"] = map[string]interface{}{
+				"Timestamp": now.UnixNano() / int64(time.Millisecond),
+				"CloudWatchMetrics": []map[string]interface{}{{
+					"Namespace":  w.namespace,
+					"Dimensions": [][]string{dims},
+					"Metrics":    metrics,
+				}},
+			}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	// Only a complete write moves the baseline, a failed one is written again next interval
+	w.last = last
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ProcessedItems", "processed_items"},
+		{"MyApp/ProcessedItems", "my_app_processed_items"},
+		{"HTTPRequests", "http_requests"},
+		{"latency-p99", "latency_p99"},
+		{"4xxErrors", "_4xx_errors"},
+		{"!!", ""},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEMFCollectorIngest(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		want    string
+		wantErr bool
+		errors  float64
+	}{
+		{
+			name:   "dimensions become labels",
+			record: `{"_aws":{"CloudWatchMetrics":[{"Namespace":"Shop","Dimensions":[["ServiceName"]],"Metrics":[{"Name":"Orders","Unit":"Count"}]}]},"ServiceName":"cart","Orders":2}`,
+			want: `
+# HELP shop_orders_total Metric ingested from CloudWatch Embedded Metric Format records
+# TYPE shop_orders_total counter
+shop_orders_total{service_name="cart"} 2
+`,
+		},
+		{
+			name:    "dimension without a valid label name",
+			record:  `{"_aws":{"CloudWatchMetrics":[{"Namespace":"Shop","Dimensions":[["!!"],["ServiceName"]],"Metrics":[{"Name":"Orders","Unit":"Count"}]}]},"!!":"x","ServiceName":"cart","Orders":2}`,
+			wantErr: true,
+			errors:  1,
+			want: `
+# HELP shop_orders_total Metric ingested from CloudWatch Embedded Metric Format records
+# TYPE shop_orders_total counter
+shop_orders_total{service_name="cart"} 2
+`,
+		},
+		{
+			name:    "job dimension",
+			record:  `{"_aws":{"CloudWatchMetrics":[{"Namespace":"Shop","Dimensions":[["Job"],["ServiceName"]],"Metrics":[{"Name":"Orders","Unit":"Count"}]}]},"Job":"nightly","ServiceName":"cart","Orders":2}`,
+			wantErr: true,
+			errors:  1,
+			want: `
+# HELP shop_orders_total Metric ingested from CloudWatch Embedded Metric Format records
+# TYPE shop_orders_total counter
+shop_orders_total{service_name="cart"} 2
+`,
+		},
+		{
+			name:    "missing dimension",
+			record:  `{"_aws":{"CloudWatchMetrics":[{"Namespace":"Shop","Dimensions":[["ServiceName"]],"Metrics":[{"Name":"Orders","Unit":"Count"}]}]},"Orders":2}`,
+			wantErr: true,
+		},
+		{
+			name:    "declared metric missing",
+			record:  `{"_aws":{"CloudWatchMetrics":[{"Namespace":"Shop","Dimensions":[],"Metrics":[{"Name":"Orders","Unit":"Count"}]}]}}`,
+			wantErr: true,
+			errors:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PluginContext{}
+			p.Name = "test_emf"
+			p.EMF.Buckets = prometheus.DefBuckets
+			var f FBEMF
+			f.NewMetric(p)
+
+			record, err := ParseEMFRecord(tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			isEMF, err := f.Handle.Ingest(record, prometheus.Labels{})
+			if !isEMF {
+				t.Fatal("record not recognized as EMF")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Ingest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := testutil.ToFloat64(f.Handle.Errors); got != tt.errors {
+				t.Errorf("errors = %v, want %v", got, tt.errors)
+			}
+			if err := testutil.CollectAndCompare(f.Handle, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestEMFWriterDeltas(t *testing.T) {
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total", Help: "test"})
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth", Help: "test"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "test"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(requests, depth, latency)
+
+	var out bytes.Buffer
+	w := &EMFWriter{namespace: "test", out: &out, last: make(map[string]float64), gatherer: registry, logger: log.NewNopLogger(), stop: make(chan struct{})}
+
+	tests := []struct {
+		name   string
+		update func()
+		want   map[string]float64
+	}{
+		{
+			name: "first write holds the increase since startup",
+			update: func() {
+				requests.Add(5)
+				depth.Set(3)
+				latency.Observe(1)
+			},
+			want: map[string]float64{"requests_total": 5, "queue_depth": 3, "latency_seconds_count": 1, "latency_seconds_sum": 1},
+		},
+		{
+			name: "counters written as their increase",
+			update: func() {
+				requests.Add(2)
+				latency.Observe(2)
+				latency.Observe(3)
+			},
+			want: map[string]float64{"requests_total": 2, "queue_depth": 3, "latency_seconds_count": 2, "latency_seconds_sum": 5},
+		},
+		{
+			name:   "no change",
+			update: func() {},
+			want:   map[string]float64{"requests_total": 0, "queue_depth": 3, "latency_seconds_count": 0, "latency_seconds_sum": 0},
+		},
+		{
+			name: "reset counts the whole value",
+			update: func() {
+				requests = prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total", Help: "test"})
+				requests.Add(1)
+				registry = prometheus.NewRegistry()
+				registry.MustRegister(requests, depth, latency)
+				w.gatherer = registry
+			},
+			want: map[string]float64{"requests_total": 1, "queue_depth": 3, "latency_seconds_count": 0, "latency_seconds_sum": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update()
+			out.Reset()
+			if err := w.write(time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+				t.Fatalf("decoding %q: %v", out.String(), err)
+			}
+			for name, want := range tt.want {
+				if got, _ := record[name].(float64); got != want {
+					t.Errorf("%s = %v, want %v", name, record[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestEMFWriterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total", Help: "test"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(requests)
+
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.EMFOutput = filepath.Join(dir, "metrics.json")
+	p.EMFNamespace = "test"
+	p.EMFInterval = time.Hour
+	w, err := NewEMFWriter(p, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+
+	if err := w.write(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+
+	// Writes after Close are dropped rather than failing on the closed file
+	if err := w.write(time.Now()); err != nil {
+		t.Errorf("write() after Close() = %v", err)
+	}
+	b, err := ioutil.ReadFile(p.EMFOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 1 {
+		t.Errorf("%d lines written, want 1", n)
+	}
+}
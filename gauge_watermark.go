@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WatermarkCollector Gauge that only moves when a value exceeds (Max) or undercuts (Min) the current value.
+// prometheus.Gauge offers no compare-and-set, so the series are tracked here.
+type WatermarkCollector struct {
+	mu            sync.Mutex
+	desc          *prometheus.Desc
+	labelNames    []string
+	max           bool
+	resetInterval time.Duration
+	series        map[string]*watermarkSeries
+}
+
+type watermarkSeries struct {
+	labelValues []string
+	value       float64
+	windowStart time.Time
+}
+
+type FBWatermark struct {
+	Handle *WatermarkCollector
+}
+
+func (w *FBWatermark) NewMetric(p *PluginContext) {
+	w.Handle = &WatermarkCollector{
+		desc:          prometheus.NewDesc(p.Name, p.Help, p.VariableLabels, p.ConstantLabels),
+		labelNames:    p.VariableLabels,
+		max:           p.MetricData.Gauge.Method == "Max",
+		resetInterval: p.MetricData.Gauge.ResetInterval,
+		series:        make(map[string]*watermarkSeries),
+	}
+}
+
+// Observe Record v for the label set, keeping it only if it is a new high (or low) for the current window.
+// NaN and ±Inf are skipped, no later value would ever compare past them.
+func (w *WatermarkCollector) Observe(labels prometheus.Labels, v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+
+	values := labelValues(labels, w.labelNames)
+	key := labelsKey(values)
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s, ok := w.series[key]
+	if !ok {
+		w.series[key] = &watermarkSeries{labelValues: values, value: v, windowStart: now}
+		return
+	}
+
+	if w.resetInterval > 0 && now.Sub(s.windowStart) >= w.resetInterval {
+		s.value = v
+		s.windowStart = now
+		return
+	}
+
+	if (w.max && v > s.value) || (!w.max && v < s.value) {
+		s.value = v
+	}
+}
+
+func (w *WatermarkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.desc
+}
+
+func (w *WatermarkCollector) Collect(ch chan<- prometheus.Metric) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, s := range w.series {
+		ch <- prometheus.MustNewConstMetric(w.desc, prometheus.GaugeValue, s.value, s.labelValues...)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWatermarkCollectorObserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		values []float64
+		want   float64
+	}{
+		{"max keeps the highest", "Max", []float64{3, 7, 5}, 7},
+		{"min keeps the lowest", "Min", []float64{3, 7, 1, 5}, 1},
+		{"NaN first skipped", "Max", []float64{math.NaN(), 2, 4}, 4},
+		{"NaN later skipped", "Min", []float64{3, math.NaN(), 2}, 2},
+		{"+Inf skipped", "Max", []float64{3, math.Inf(1), 5}, 5},
+		{"-Inf skipped", "Min", []float64{3, math.Inf(-1), 2}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PluginContext{}
+			p.Name = "test_watermark"
+			p.Help = "test"
+			p.MetricData.Gauge.Method = tt.method
+			var w FBWatermark
+			w.NewMetric(p)
+
+			for _, v := range tt.values {
+				w.Handle.Observe(prometheus.Labels{}, v)
+			}
+			if got := testutil.ToFloat64(w.Handle); got != tt.want {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatermarkCollectorResetInterval(t *testing.T) {
+	p := &PluginContext{}
+	p.Name = "test_watermark"
+	p.Help = "test"
+	p.MetricData.Gauge.Method = "Max"
+	p.MetricData.Gauge.ResetInterval = time.Minute
+	var w FBWatermark
+	w.NewMetric(p)
+
+	w.Handle.Observe(prometheus.Labels{}, 10)
+	w.Handle.series[labelsKey(nil)].windowStart = time.Now().Add(-2 * time.Minute)
+	// The first value of a new window replaces the old high even when lower
+	w.Handle.Observe(prometheus.Labels{}, 4)
+	w.Handle.Observe(prometheus.Labels{}, 3)
+
+	if got := testutil.ToFloat64(w.Handle); got != 4 {
+		t.Errorf("value = %v, want 4", got)
+	}
+}
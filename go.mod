@@ -3,9 +3,14 @@ module github.com/neiman-marcus/fluent-bit-out-prometheus-metrics
 go 1.15
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/fluent/fluent-bit-go v0.0.0-20200729034236-b9c0d6a20853
 	github.com/go-kit/kit v0.10.0
+	github.com/go-logfmt/logfmt v0.5.0
+	github.com/golang/protobuf v1.4.3
 	github.com/prometheus/client_golang v1.8.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.14.0
 	golang.org/x/sys v0.0.0-20210119212857-b64e53b001e4 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+type heartbeatSeries struct {
+	labelValues []string
+	last        time.Time
+	declared    bool
+}
+
+// HeartbeatCollector Tracks when each label set last logged and reports it down once it has been
+// silent for longer than the expected interval.  Declared label sets are watched from startup so
+// streams that never log are caught too.
+type HeartbeatCollector struct {
+	mu          sync.Mutex
+	labelNames  []string
+	interval    time.Duration
+	learn       bool
+	forgetAfter time.Duration
+	series      map[string]*heartbeatSeries
+	sinceDesc   *prometheus.Desc
+	upDesc      *prometheus.Desc
+	stop        chan struct{}
+}
+
+type FBHeartbeat struct {
+	Handle *HeartbeatCollector
+}
+
+func (h *FBHeartbeat) NewMetric(p *PluginContext) {
+	h.Handle = &HeartbeatCollector{
+		labelNames:  p.VariableLabels,
+		interval:    p.Heartbeat.Interval,
+		learn:       p.Heartbeat.Learn,
+		forgetAfter: p.Heartbeat.ForgetAfter,
+		series:      make(map[string]*heartbeatSeries),
+		sinceDesc:   prometheus.NewDesc(p.Name+"_seconds_since_last_record", p.Help+" (seconds since the last record)", p.VariableLabels, p.ConstantLabels),
+		upDesc:      prometheus.NewDesc(p.Name+"_up", p.Help+" (1 when a record arrived within the expected interval)", p.VariableLabels, p.ConstantLabels),
+		stop:        make(chan struct{}),
+	}
+
+	// Declared label sets count from startup
+	now := time.Now()
+	for _, labels := range p.Heartbeat.Expected {
+		// Labels left out match records missing the field, as the flushed label sets do
+		values := make([]string, len(p.VariableLabels))
+		for i, n := range p.VariableLabels {
+			v, ok := labels[n]
+			if !ok {
+				v = missingLabelValue
+			}
+			values[i] = v
+		}
+		h.Handle.series[labelsKey(values)] = &heartbeatSeries{labelValues: values, last: now, declared: true}
+	}
+}
+
+// Beat Record that the label set logged at t
+func (h *HeartbeatCollector) Beat(labels prometheus.Labels, t time.Time) {
+	values := labelValues(labels, h.labelNames)
+	key := labelsKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		if !h.learn {
+			return
+		}
+		s = &heartbeatSeries{labelValues: values}
+		h.series[key] = s
+	}
+	s.last = t
+}
+
+func (h *HeartbeatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.sinceDesc
+	ch <- h.upDesc
+}
+
+func (h *HeartbeatCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, s := range h.series {
+		since := now.Sub(s.last)
+		if !s.declared && h.forgetAfter > 0 && since > h.forgetAfter {
+			// A learned label set gone for good, Ex. a decommissioned host
+			delete(h.series, key)
+			continue
+		}
+
+		var up float64
+		if since <= h.interval {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(h.sinceDesc, prometheus.GaugeValue, since.Seconds(), s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(h.upDesc, prometheus.GaugeValue, up, s.labelValues...)
+	}
+}
+
+// run Push on a ticker so silence shows at the push gateway even when no records flush
+func (h *HeartbeatCollector) run(pusher *push.Pusher, logger log.Logger) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := pusher.Add(); err != nil {
+				level.Error(logger).Log("msg", "Could not push heartbeat to pushgateway", "err", err)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// Stop End the push ticker goroutine when the plugin exits
+func (h *HeartbeatCollector) Stop() {
+	close(h.stop)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newHeartbeatCollector(learn bool, forgetAfter time.Duration, expected []prometheus.Labels) *HeartbeatCollector {
+	p := &PluginContext{}
+	p.Name = "test_heartbeat"
+	p.Help = "test"
+	p.VariableLabels = []string{"host", "app"}
+	p.Heartbeat.Interval = time.Minute
+	p.Heartbeat.Learn = learn
+	p.Heartbeat.ForgetAfter = forgetAfter
+	p.Heartbeat.Expected = expected
+	var h FBHeartbeat
+	h.NewMetric(p)
+	return h.Handle
+}
+
+// heartbeatUp The up gauge of every collected label set, keyed by host/app
+func heartbeatUp(t *testing.T, h *HeartbeatCollector) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	h.Collect(ch)
+	close(ch)
+
+	up := make(map[string]float64)
+	for m := range ch {
+		if m.Desc() != h.upDesc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		up[labels["host"]+"/"+labels["app"]] = pb.GetGauge().GetValue()
+	}
+	return up
+}
+
+func TestHeartbeatCollector(t *testing.T) {
+	type beat struct {
+		labels prometheus.Labels
+		ago    time.Duration
+	}
+
+	tests := []struct {
+		name        string
+		learn       bool
+		forgetAfter time.Duration
+		expected    []prometheus.Labels
+		beats       []beat
+		// startedAgo Shifts the declared label sets back in time
+		startedAgo time.Duration
+		want       map[string]float64
+	}{
+		{
+			name:     "declared label sets up from startup",
+			expected: []prometheus.Labels{{"host": "web1", "app": "api"}},
+			want:     map[string]float64{"web1/api": 1},
+		},
+		{
+			name:       "silent declared label set goes down",
+			expected:   []prometheus.Labels{{"host": "web1", "app": "api"}},
+			startedAgo: 2 * time.Minute,
+			want:       map[string]float64{"web1/api": 0},
+		},
+		{
+			name:       "record with a missing field matches a declared set leaving it out",
+			expected:   []prometheus.Labels{{"host": "web1"}},
+			startedAgo: 2 * time.Minute,
+			beats:      []beat{{prometheus.Labels{"host": "web1", "app": missingLabelValue}, 0}},
+			want:       map[string]float64{"web1/" + missingLabelValue: 1},
+		},
+		{
+			name:  "learned label sets",
+			learn: true,
+			beats: []beat{
+				{prometheus.Labels{"host": "web1", "app": "api"}, 10 * time.Second},
+				{prometheus.Labels{"host": "web2", "app": "api"}, 5 * time.Minute},
+			},
+			want: map[string]float64{"web1/api": 1, "web2/api": 0},
+		},
+		{
+			name:  "not learning ignores undeclared label sets",
+			beats: []beat{{prometheus.Labels{"host": "web1", "app": "api"}, 0}},
+			want:  map[string]float64{},
+		},
+		{
+			name:        "learned label set forgotten, declared kept",
+			learn:       true,
+			forgetAfter: 10 * time.Minute,
+			expected:    []prometheus.Labels{{"host": "web1", "app": "api"}},
+			startedAgo:  time.Hour,
+			beats:       []beat{{prometheus.Labels{"host": "web2", "app": "api"}, time.Hour}},
+			want:        map[string]float64{"web1/api": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHeartbeatCollector(tt.learn, tt.forgetAfter, tt.expected)
+			defer h.Stop()
+			for _, s := range h.series {
+				s.last = s.last.Add(-tt.startedAgo)
+			}
+			for _, b := range tt.beats {
+				h.Beat(b.labels, time.Now().Add(-b.ago))
+			}
+
+			got := heartbeatUp(t, h)
+			if len(got) != len(tt.want) {
+				t.Fatalf("up = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("up{%s} = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestHeartbeatPushesUntilStopped(t *testing.T) {
+	var pushes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pushes, 1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	h := newHeartbeatCollector(false, 0, []prometheus.Labels{{"host": "web1", "app": "api"}})
+	h.interval = 10 * time.Millisecond
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(h)
+	pusher := push.New(srv.URL, "test").Gatherer(registry)
+
+	done := make(chan struct{})
+	go func() {
+		h.run(pusher, log.NewNopLogger())
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&pushes) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&pushes); n < 2 {
+		t.Fatalf("%d pushes without records, want at least 2", n)
+	}
+
+	h.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after Stop")
+	}
+	stopped := atomic.LoadInt32(&pushes)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&pushes); n != stopped {
+		t.Errorf("%d pushes after Stop, want none", n-stopped)
+	}
+}
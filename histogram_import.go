@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type importedSeries struct {
+	labelValues []string
+	buckets     map[float64]uint64
+	count       uint64
+	sum         float64
+}
+
+// countAt Cumulative count of the highest bound at or below b, 0 below every bound
+func countAt(buckets map[float64]uint64, b float64) uint64 {
+	best, found := math.Inf(-1), false
+	for ub := range buckets {
+		if ub <= b && ub >= best {
+			best, found = ub, true
+		}
+	}
+	if !found {
+		return 0
+	}
+	return buckets[best]
+}
+
+// ImportedHistogram Histogram fed with bucket counts already aggregated by the service, Ex. a periodic
+// stats log line, rather than with single observations.  Each record adds its counts to the series.
+type ImportedHistogram struct {
+	mu         sync.Mutex
+	labelNames []string
+	bounds     []float64
+	prefix     string
+	perBucket  bool
+	series     map[string]*importedSeries
+	desc       *prometheus.Desc
+}
+
+// NewImportedHistogram bounds are those of the configured bucket type, used for array bucket fields
+func NewImportedHistogram(p *PluginContext, bounds []float64) *ImportedHistogram {
+	return &ImportedHistogram{
+		labelNames: p.VariableLabels,
+		bounds:     bounds,
+		prefix:     p.Histogram.ImportBucketPrefix,
+		perBucket:  p.Histogram.ImportBucketCounts == "PerBucket",
+		series:     make(map[string]*importedSeries),
+		desc:       prometheus.NewDesc(p.Name, p.Help, p.VariableLabels, p.ConstantLabels),
+	}
+}
+
+// ParseBuckets Read the bucket counts of a record into cumulative counts by upper bound.
+// v is an object keyed by bound, an array matching the configured bounds with an optional
+// trailing +Inf entry, or nil to scan the record for keys starting with the prefix.
+func (h *ImportedHistogram) ParseBuckets(v interface{}, records map[string]interface{}) (map[float64]uint64, error) {
+	raw := make(map[float64]float64)
+
+	switch b := v.(type) {
+	case nil:
+		for k, c := range records {
+			if !strings.HasPrefix(k, h.prefix) {
+				continue
+			}
+			if err := addBucket(raw, strings.TrimPrefix(k, h.prefix), c); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k, c := range b {
+			if err := addBucket(raw, strings.TrimPrefix(k, h.prefix), c); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		if len(b) != len(h.bounds) && len(b) != len(h.bounds)+1 {
+			return nil, fmt.Errorf("%d bucket counts for %d bounds", len(b), len(h.bounds))
+		}
+		for i, c := range b {
+			ub := math.Inf(1)
+			if i < len(h.bounds) {
+				ub = h.bounds[i]
+			}
+			if err := addBucket(raw, strconv.FormatFloat(ub, 'g', -1, 64), c); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("bucket field is neither an object nor an array")
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("no bucket counts in record")
+	}
+
+	bounds := make([]float64, 0, len(raw))
+	for ub := range raw {
+		bounds = append(bounds, ub)
+	}
+	sort.Float64s(bounds)
+
+	buckets := make(map[float64]uint64, len(raw))
+	var running float64
+	for _, ub := range bounds {
+		if h.perBucket {
+			running += raw[ub]
+		} else {
+			// Never let a cumulative count fall below the bucket before it
+			running = math.Max(running, raw[ub])
+		}
+		buckets[ub] = uint64(running)
+	}
+	return buckets, nil
+}
+
+// addBucket Parse a bound, accepting inf and +Inf spellings, and its count
+func addBucket(raw map[float64]float64, bound string, count interface{}) error {
+	ub, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return fmt.Errorf("bucket bound %q: %v", bound, err)
+	}
+	c, err := strconv.ParseFloat(fmt.Sprintf("%v", count), 64)
+	if err != nil || c < 0 {
+		return fmt.Errorf("bucket %q count %v is not a non-negative number", bound, count)
+	}
+	raw[ub] = c
+	return nil
+}
+
+// Merge Add the bucket counts, count and sum of one record.  The count is never below the +Inf
+// bucket, or the highest bound when there is none, so a negative count is taken from that bucket.
+func (h *ImportedHistogram) Merge(labels prometheus.Labels, buckets map[float64]uint64, count float64, sum float64) {
+	// A count below the buckets would export _count under the +Inf bucket
+	if top := float64(countAt(buckets, math.Inf(1))); !(count >= top) {
+		count = top
+	}
+
+	values := labelValues(labels, h.labelNames)
+	key := labelsKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &importedSeries{labelValues: values, buckets: make(map[float64]uint64)}
+		h.series[key] = s
+	}
+
+	// A bound new to the series starts from the series count just below it, keeping buckets cumulative
+	for ub := range buckets {
+		if _, ok := s.buckets[ub]; !ok {
+			s.buckets[ub] = countAt(s.buckets, ub)
+		}
+	}
+	// A bound missing from the record gains the record count just below it
+	for ub := range s.buckets {
+		s.buckets[ub] += countAt(buckets, ub)
+	}
+	s.count += uint64(count)
+	s.sum += sum
+}
+
+func (h *ImportedHistogram) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *ImportedHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.series {
+		buckets := make(map[float64]uint64, len(s.buckets))
+		for ub, c := range s.buckets {
+			// The client library adds +Inf from the count
+			if !math.IsInf(ub, 1) {
+				buckets[ub] = c
+			}
+		}
+		ch <- prometheus.MustNewConstHistogram(h.desc, s.count, s.sum, buckets, s.labelValues...)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCountAt(t *testing.T) {
+	buckets := map[float64]uint64{0.1: 5, 0.5: 12, math.Inf(1): 14}
+
+	tests := []struct {
+		bound float64
+		want  uint64
+	}{
+		{0.05, 0},
+		{0.1, 5},
+		{0.3, 5},
+		{0.5, 12},
+		{10, 12},
+		{math.Inf(1), 14},
+	}
+	for _, tt := range tests {
+		if got := countAt(buckets, tt.bound); got != tt.want {
+			t.Errorf("countAt(%v) = %v, want %v", tt.bound, got, tt.want)
+		}
+	}
+}
+
+func TestImportedHistogramParseBuckets(t *testing.T) {
+	inf := math.Inf(1)
+
+	tests := []struct {
+		name      string
+		perBucket bool
+		field     interface{}
+		records   map[string]interface{}
+		want      map[float64]uint64
+		wantErr   bool
+	}{
+		{
+			name:    "prefixed keys",
+			records: map[string]interface{}{"le_0.1": 5, "le_0.5": "12", "le_inf": 14.0, "latency_sum": 3.2},
+			want:    map[float64]uint64{0.1: 5, 0.5: 12, inf: 14},
+		},
+		{
+			name:  "object with and without prefix",
+			field: map[string]interface{}{"le_1": 2, "+Inf": 3},
+			want:  map[float64]uint64{1: 2, inf: 3},
+		},
+		{
+			name:  "array with trailing inf",
+			field: []interface{}{1, 4, 6},
+			want:  map[float64]uint64{1: 1, 2: 4, inf: 6},
+		},
+		{
+			name:      "per bucket counts are summed",
+			perBucket: true,
+			field:     []interface{}{1, 3, 2},
+			want:      map[float64]uint64{1: 1, 2: 4, inf: 6},
+		},
+		{
+			name:  "cumulative counts never decrease",
+			field: map[string]interface{}{"1": 5, "2": 3},
+			want:  map[float64]uint64{1: 5, 2: 5},
+		},
+		{name: "array length mismatch", field: []interface{}{1}, wantErr: true},
+		{name: "negative count", field: map[string]interface{}{"1": -1}, wantErr: true},
+		{name: "bad bound", field: map[string]interface{}{"slow": 1}, wantErr: true},
+		{name: "no buckets", records: map[string]interface{}{"latency_sum": 3.2}, wantErr: true},
+		{name: "scalar field", field: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ImportedHistogram{bounds: []float64{1, 2}, prefix: "le_", perBucket: tt.perBucket}
+			got, err := h.ParseBuckets(tt.field, tt.records)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBuckets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBuckets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportedHistogramMerge(t *testing.T) {
+	inf := math.Inf(1)
+	type record struct {
+		buckets map[float64]uint64
+		count   float64
+		sum     float64
+	}
+
+	tests := []struct {
+		name    string
+		records []record
+		want    string
+	}{
+		{
+			name:    "count taken from inf bucket",
+			records: []record{{map[float64]uint64{1: 2, inf: 3}, -1, 4}},
+			want: `
+test_imported_bucket{le="1"} 2
+test_imported_bucket{le="+Inf"} 3
+test_imported_sum 4
+test_imported_count 3
+`,
+		},
+		{
+			name:    "count below the buckets is raised",
+			records: []record{{map[float64]uint64{1: 2, 5: 7}, 4, 9}},
+			want: `
+test_imported_bucket{le="1"} 2
+test_imported_bucket{le="5"} 7
+test_imported_bucket{le="+Inf"} 7
+test_imported_sum 9
+test_imported_count 7
+`,
+		},
+		{
+			name:    "count above the buckets is kept",
+			records: []record{{map[float64]uint64{1: 2}, 5, 9}},
+			want: `
+test_imported_bucket{le="1"} 2
+test_imported_bucket{le="+Inf"} 5
+test_imported_sum 9
+test_imported_count 5
+`,
+		},
+		{
+			name:    "not a number count taken from the buckets",
+			records: []record{{map[float64]uint64{1: 2}, math.NaN(), 1}},
+			want: `
+test_imported_bucket{le="1"} 2
+test_imported_bucket{le="+Inf"} 2
+test_imported_sum 1
+test_imported_count 2
+`,
+		},
+		{
+			name: "bounds learned across records stay cumulative",
+			records: []record{
+				{map[float64]uint64{1: 2, inf: 3}, -1, 1},
+				{map[float64]uint64{0.5: 1, 2: 4, inf: 5}, -1, 2},
+			},
+			want: `
+test_imported_bucket{le="0.5"} 1
+test_imported_bucket{le="1"} 3
+test_imported_bucket{le="2"} 6
+test_imported_bucket{le="+Inf"} 8
+test_imported_sum 3
+test_imported_count 8
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PluginContext{}
+			p.Name = "test_imported"
+			p.Help = "test"
+			h := NewImportedHistogram(p, nil)
+			for _, r := range tt.records {
+				h.Merge(prometheus.Labels{}, r.buckets, r.count, r.sum)
+			}
+
+			want := "# HELP test_imported test\n# TYPE test_imported histogram" + tt.want
+			if err := testutil.CollectAndCompare(h, strings.NewReader(want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseEventTime Parse a record field holding an event time using a Go time layout, or Unix / UnixMilli epochs
+func ParseEventTime(v interface{}, layout string) (time.Time, error) {
+	if v == nil {
+		return time.Time{}, fmt.Errorf("field not present")
+	}
+	s := fmt.Sprintf("%v", v)
+
+	switch layout {
+	case "Unix", "UnixMilli":
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return time.Time{}, fmt.Errorf("epoch %v is not finite", f)
+		}
+		if layout == "UnixMilli" {
+			f /= 1000
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	default:
+		return time.Parse(layout, s)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEventTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      interface{}
+		layout  string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:   "RFC3339 with fraction",
+			in:     "2020-09-13T12:26:40.25Z",
+			layout: time.RFC3339Nano,
+			want:   time.Date(2020, 9, 13, 12, 26, 40, 250000000, time.UTC),
+		},
+		{
+			name:   "custom layout",
+			in:     "13/Sep/2020:12:26:40 +0000",
+			layout: "02/Jan/2006:15:04:05 -0700",
+			want:   time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC),
+		},
+		{name: "layout mismatch", in: "2020-09-13", layout: time.RFC3339, wantErr: true},
+		{name: "Unix string", in: "1600000000", layout: "Unix", want: time.Unix(1600000000, 0)},
+		{name: "Unix float with fraction", in: 1600000000.5, layout: "Unix", want: time.Unix(1600000000, 500000000)},
+		{name: "Unix int", in: int64(1600000000), layout: "Unix", want: time.Unix(1600000000, 0)},
+		{name: "Unix padded", in: " 1600000000\n", layout: "Unix", want: time.Unix(1600000000, 0)},
+		{name: "UnixMilli", in: "1600000000250", layout: "UnixMilli", want: time.Unix(1600000000, 250000000)},
+		{name: "Unix not a number", in: "yesterday", layout: "Unix", wantErr: true},
+		{name: "Unix NaN", in: "NaN", layout: "Unix", wantErr: true},
+		{name: "UnixMilli infinite", in: "+Inf", layout: "UnixMilli", wantErr: true},
+		{name: "missing field", in: nil, layout: "Unix", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEventTime(tt.in, tt.layout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEventTime(%v, %q) error = %v, wantErr %v", tt.in, tt.layout, err, tt.wantErr)
+			}
+			// Float epochs are only exact to about a microsecond
+			if !tt.wantErr && (got.Sub(tt.want) > time.Microsecond || tt.want.Sub(got) > time.Microsecond) {
+				t.Errorf("ParseEventTime(%v, %q) = %v, want %v", tt.in, tt.layout, got, tt.want)
+			}
+		})
+	}
+}
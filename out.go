@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+	"math"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -24,8 +27,14 @@ const pluginName = "prometheus_metrics"
 var re *regexp.Regexp
 
 type Histogram struct {
-	BucketType string
-	ObserveKey string
+	BucketType         string
+	ObserveKey         string
+	Mode               string
+	ImportBucketsKey   string
+	ImportBucketPrefix string
+	ImportBucketCounts string
+	ImportSumKey       string
+	ImportCountKey     string
 	LinearBucketData
 	ExponentialBucketData
 }
@@ -40,21 +49,157 @@ type ExponentialBucketData struct {
 	Start  string
 }
 
+type Counter struct {
+	Mode       string
+	SourceKey  string
+	PatternKey string
+	Patterns   []string
+}
+
+type Correlation struct {
+	IDKey      string
+	EventKey   string
+	StartValue string
+	EndValue   string
+	TTL        time.Duration
+	MaxPending int
+}
+
+type Lag struct {
+	TimeKey    string
+	TimeLayout string
+}
+
+type Distinct struct {
+	Key       string
+	Window    time.Duration
+	Precision uint8
+}
+
+type TopK struct {
+	Key      string
+	K        int
+	Capacity int
+	Window   time.Duration
+	Label    string
+}
+
+type Window struct {
+	ObserveKey string
+	Mode       string
+	Length     time.Duration
+	Step       time.Duration
+}
+
+type Sketch struct {
+	ObserveKey       string
+	RelativeAccuracy float64
+	Quantiles        []float64
+	ExportURL        string
+	ExportInterval   time.Duration
+}
+
+type Template struct {
+	Key          string
+	MaxTemplates int
+	Similarity   float64
+	Depth        int
+	Label        string
+}
+
+type SLO struct {
+	BadCondition    *RecordCondition
+	IgnoreCondition *RecordCondition
+	Objective       float64
+	Windows         []time.Duration
+}
+
+type Apdex struct {
+	ObserveKey string
+	Threshold  float64
+	Window     time.Duration
+}
+
+type Heartbeat struct {
+	Interval    time.Duration
+	Expected    []prometheus.Labels
+	Learn       bool
+	ForgetAfter time.Duration
+}
+
+type Sequence struct {
+	Key        string
+	StreamKey  string
+	Window     int
+	MaxStreams int
+}
+
+type Passthrough struct {
+	Key       string
+	TagLabels map[string]string
+	TTL       time.Duration
+	MaxSeries int
+}
+
+type StatsD struct {
+	Key          string
+	Mappings     []*StatsDMapping
+	DropUnmapped bool
+	Buckets      []float64
+	MaxSeries    int
+}
+
+type EMF struct {
+	Key                string
+	Buckets            []float64
+	MaxSeries          int
+	MaxSeriesPerMetric int
+}
+
+type Anomaly struct {
+	Interval   time.Duration
+	ObserveKey string
+	Alpha      float64
+	Threshold  float64
+	Events     string
+}
+
 type Summary struct {
 	ObserveKey string
 }
 
 type Gauge struct {
-	Method string
-	SetKey string
-	AddKey string
-	SubKey string
+	Method        string
+	SetKey        string
+	AddKey        string
+	SubKey        string
+	MaxKey        string
+	MinKey        string
+	ResetInterval time.Duration
+	MethodKey     string
+	MethodMap     map[string]string
 }
 
 type MetricData struct {
+	Counter
 	Gauge
 	Summary
 	Histogram
+	Correlation
+	Lag
+	Distinct
+	TopK
+	Window
+	Sketch
+	Template
+	SLO
+	Apdex
+	Heartbeat
+	Sequence
+	Passthrough
+	StatsD
+	EMF
+	Anomaly
 	Type           string
 	Name           string
 	Help           string
@@ -64,7 +209,7 @@ type MetricData struct {
 
 // SetMetricType Set context metric_type
 // Required: Yes
-// Values: Counter, Gauge, Summary, Histogram
+// Values: Counter, Gauge, Summary, Histogram, Lag, Distinct, TopK, Window, Sketch, Template, SLO, Apdex, Heartbeat, Sequence, Passthrough, StatsD, EMF
 func (m *MetricData) SetMetricType(t string) {
 	m.Type = ConfigKeyQuoteTrim(t)
 }
@@ -85,14 +230,108 @@ func (m *MetricData) IsCounter() bool {
 	return m.Type == "Counter"
 }
 
+func (m *MetricData) IsCumulativeCounter() bool {
+	return m.IsCounter() && m.Counter.Mode == "Cumulative"
+}
+
+// IsPatternCounter Counter incremented once per named regex matching a field, labelled by pattern
+func (m *MetricData) IsPatternCounter() bool {
+	return m.IsCounter() && m.Counter.Mode == "Pattern"
+}
+
 func (m *MetricData) IsGauge() bool {
 	return m.Type == "Gauge"
 }
 
+// IsWatermarkGauge Max and Min gauges are served by a custom collector rather than a GaugeVec
+func (m *MetricData) IsWatermarkGauge() bool {
+	return m.IsGauge() && (m.Gauge.Method == "Max" || m.Gauge.Method == "Min")
+}
+
 func (m *MetricData) IsHistogram() bool {
 	return m.Type == "Histogram"
 }
 
+// IsImportedHistogram Histogram merging bucket counts pre-aggregated by the service
+func (m *MetricData) IsImportedHistogram() bool {
+	return m.IsHistogram() && m.Histogram.Mode == "Import"
+}
+
+// IsLag Histogram of the delay between the record (or event) time and the flush
+func (m *MetricData) IsLag() bool {
+	return m.Type == "Lag"
+}
+
+// IsDistinct Gauge of the estimated number of distinct values of a field over a rolling window
+func (m *MetricData) IsDistinct() bool {
+	return m.Type == "Distinct"
+}
+
+// IsTopK Gauge of the most frequent values of a field per window, everything else summed under other
+func (m *MetricData) IsTopK() bool {
+	return m.Type == "TopK"
+}
+
+// IsWindow Gauges of rate, average, min, max and count of a field per window
+func (m *MetricData) IsWindow() bool {
+	return m.Type == "Window"
+}
+
+// IsSketch Mergeable DDSketch quantiles, an alternative to Summary
+func (m *MetricData) IsSketch() bool {
+	return m.Type == "Sketch"
+}
+
+// IsTemplate Counter of records per log template discovered by the Drain miner
+func (m *MetricData) IsTemplate() bool {
+	return m.Type == "Template"
+}
+
+// IsSLO Good/bad event counters with multi-window error ratio and burn rate gauges
+func (m *MetricData) IsSLO() bool {
+	return m.Type == "SLO"
+}
+
+// IsApdex Apdex score and satisfied/tolerating/frustrated counts of a latency field
+func (m *MetricData) IsApdex() bool {
+	return m.Type == "Apdex"
+}
+
+// IsHeartbeat Seconds since the last record and up gauges per label set
+func (m *MetricData) IsHeartbeat() bool {
+	return m.Type == "Heartbeat"
+}
+
+// IsSequence Gap, duplicate and out-of-order counters of producer sequence numbers
+func (m *MetricData) IsSequence() bool {
+	return m.Type == "Sequence"
+}
+
+// IsPassthrough Relay of Prometheus exposition text carried in a record field
+func (m *MetricData) IsPassthrough() bool {
+	return m.Type == "Passthrough"
+}
+
+// IsStatsD Counters, gauges and histograms created from StatsD lines carried in a record field
+func (m *MetricData) IsStatsD() bool {
+	return m.Type == "StatsD"
+}
+
+// IsEMF Metrics declared by CloudWatch Embedded Metric Format records
+func (m *MetricData) IsEMF() bool {
+	return m.Type == "EMF"
+}
+
+// HasAnomalyDetection EWMA z-score gauges kept alongside any metric type
+func (m *MetricData) HasAnomalyDetection() bool {
+	return m.Anomaly.Interval > 0
+}
+
+// IsCorrelated Summary and Histogram observe the time between correlated start and end records
+func (m *MetricData) IsCorrelated() bool {
+	return (m.IsSummary() || (m.IsHistogram() && !m.IsImportedHistogram())) && len(m.Correlation.IDKey) != 0
+}
+
 func (m *MetricData) IsExponentialBucket() bool {
 	return m.Histogram.BucketType == "Exponential"
 }
@@ -109,6 +348,8 @@ func (m *MetricData) IsSummary() bool {
 type PluginContext struct {
 	MetricData
 	Metric
+	RecordDecoder           FieldDecoder
+	RecordDedup             RecordDeduplicator
 	ID                      string
 	LogLevel                string
 	Job                     string
@@ -117,6 +358,17 @@ type PluginContext struct {
 	Logger                  log.Logger
 	PushGatewayRetries      int64
 	PushGatewayRetryCounter int64
+	AlertmanagerURL         string
+	AlertRules              []*AlertRule
+	AlertInterval           time.Duration
+	Alerter                 *Alerter
+	Webhooks                []*WebhookTarget
+	WebhookInterval         time.Duration
+	Notifier                *Notifier
+	EMFOutput               string
+	EMFNamespace            string
+	EMFInterval             time.Duration
+	EMFWriter               *EMFWriter
 }
 
 // SetPluginID Set context id
@@ -166,6 +418,152 @@ func (p *PluginContext) SetPushGatewayRetries(u string, logger log.Logger) {
 	}
 }
 
+// SetAlertmanagerURL Set context alertmanager_url
+// Required with alert_rule_1: Yes
+// Note: /api/v2/alerts is appended unless already present
+func (p *PluginContext) SetAlertmanagerURL(u string, logger log.Logger) {
+	if len(u) != 0 {
+		p.AlertmanagerURL = u
+	} else {
+		level.Error(logger).Log("msg", "alertmanager_url not populated")
+		panic(1)
+	}
+}
+
+// SetAlertRules Set context alert_rule_1 ... alert_rule_N
+// Required: No
+// Note: Each key holds one NAME EXPR OP THRESHOLD [for DURATION] rule.  Numbering stops at the first missing key.
+func (p *PluginContext) SetAlertRules(rules []string, logger log.Logger) {
+	for _, r := range rules {
+		rule, err := ParseAlertRule(ConfigKeyQuoteTrim(r))
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid alert rule", "err", err)
+			panic(err)
+		}
+		p.AlertRules = append(p.AlertRules, rule)
+	}
+}
+
+// SetAlertEvaluationInterval Set context alert_evaluation_interval
+// Required: No
+// Default: 15s
+func (p *PluginContext) SetAlertEvaluationInterval(i string, logger log.Logger) {
+	p.AlertInterval = 15 * time.Second
+	if len(i) != 0 {
+		d, err := time.ParseDuration(i)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "alert_evaluation_interval not a valid duration of at least 1s, defaulting to 15s.", "err", err)
+			return
+		}
+		p.AlertInterval = d
+	}
+}
+
+// AddWebhook Set context webhook_N_url, webhook_N_condition, webhook_N_template, webhook_N_name,
+// webhook_N_min_interval, webhook_N_retries and webhook_N_backoff
+// Required: webhook_N_url and webhook_N_condition
+// Default: webhook_N_name webhook_N, webhook_N_min_interval 10s, webhook_N_retries 3, webhook_N_backoff 1s
+func (p *PluginContext) AddWebhook(n int, key func(string) string, logger log.Logger) {
+	prefix := fmt.Sprintf("webhook_%d_", n)
+
+	name := ConfigKeyQuoteTrim(key("name"))
+	if len(name) == 0 {
+		name = fmt.Sprintf("webhook_%d", n)
+	}
+	condition := ConfigKeyQuoteTrim(key("condition"))
+	if len(condition) == 0 {
+		level.Error(logger).Log("msg", prefix+"condition not populated")
+		panic(1)
+	}
+
+	t, err := NewWebhookTarget(name, key("url"), condition, key("template"))
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid webhook", "err", err)
+		panic(err)
+	}
+
+	t.MinInterval = 10 * time.Second
+	if v := key("min_interval"); len(v) != 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			level.Error(logger).Log("msg", prefix+"min_interval not a valid duration, defaulting to 10s.", "err", err)
+		} else {
+			t.MinInterval = d
+		}
+	}
+
+	t.Retries = 3
+	if v := key("retries"); len(v) != 0 {
+		r, err := strconv.Atoi(v)
+		if err != nil || r < 0 {
+			level.Error(logger).Log("msg", prefix+"retries not a non-negative integer, defaulting to 3.", "err", err)
+		} else {
+			t.Retries = r
+		}
+	}
+
+	t.Backoff = time.Second
+	if v := key("backoff"); len(v) != 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			level.Error(logger).Log("msg", prefix+"backoff not a positive duration, defaulting to 1s.", "err", err)
+		} else {
+			t.Backoff = d
+		}
+	}
+
+	p.Webhooks = append(p.Webhooks, t)
+}
+
+// SetWebhookEvaluationInterval Set context webhook_evaluation_interval
+// Required: No
+// Default: 15s
+func (p *PluginContext) SetWebhookEvaluationInterval(i string, logger log.Logger) {
+	p.WebhookInterval = 15 * time.Second
+	if len(i) != 0 {
+		d, err := time.ParseDuration(i)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "webhook_evaluation_interval not a valid duration of at least 1s, defaulting to 15s.", "err", err)
+			return
+		}
+		p.WebhookInterval = d
+	}
+}
+
+// SetEMFOutput Set context emf_output
+// Required: No
+// Values: stdout, or a file path appended to
+// Note: Enables writing the plugin's metrics as CloudWatch Embedded Metric Format JSON lines
+func (p *PluginContext) SetEMFOutput(o string) {
+	p.EMFOutput = ConfigKeyQuoteTrim(o)
+}
+
+// SetEMFNamespace Set context emf_namespace
+// Required: No
+// Default: job
+func (p *PluginContext) SetEMFNamespace(n string) {
+	if len(n) != 0 {
+		p.EMFNamespace = ConfigKeyQuoteTrim(n)
+	} else {
+		p.EMFNamespace = p.Job
+	}
+}
+
+// SetEMFOutputInterval Set context emf_output_interval
+// Required: No
+// Default: 60s
+func (p *PluginContext) SetEMFOutputInterval(i string, logger log.Logger) {
+	p.EMFInterval = time.Minute
+	if len(i) != 0 {
+		d, err := time.ParseDuration(i)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "emf_output_interval not a valid duration of at least 1s, defaulting to 60s.", "err", err)
+			return
+		}
+		p.EMFInterval = d
+	}
+}
+
 // SetMetricConstantLabels Set context metric_constant_labels
 // Required: No
 func (m *MetricData) SetMetricConstantLabels(l string, logger log.Logger) {
@@ -186,6 +584,62 @@ func (p *PluginContext) SetMetricVariableLabels(k string) {
 	}
 }
 
+// SetMetricCounterMode Set context metric_counter_mode
+// Required: No
+// Values: Inc, Cumulative, Pattern
+// Default: Inc
+func (p *PluginContext) SetMetricCounterMode(m string, logger log.Logger) {
+	switch m {
+	case "":
+		p.MetricData.Counter.Mode = "Inc"
+	case "Inc", "Cumulative", "Pattern":
+		p.MetricData.Counter.Mode = m
+	default:
+		level.Error(logger).Log("msg", "Unknown metric_counter_mode", "mode", m)
+		panic(1)
+	}
+}
+
+// SetMetricCounterSourceKey Set context metric_counter_source_key
+// Required with Counter Cumulative: Yes
+func (p *PluginContext) SetMetricCounterSourceKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Counter.SourceKey = k
+	} else {
+		level.Error(logger).Log("msg", "metric_counter_source_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricCounterPatternKey Set context metric_counter_pattern_key
+// Required with Counter Pattern: Yes
+func (p *PluginContext) SetMetricCounterPatternKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Counter.PatternKey = k
+	} else {
+		level.Error(logger).Log("msg", "metric_counter_pattern_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricCounterPatterns Set context metric_counter_pattern_1 ... metric_counter_pattern_N
+// Required with Counter Pattern: Yes
+// Note: Each key holds one name:regex pair, Ex. timeout:(?i)timed out.  Numbering stops at the first missing key.
+// Separate keys keep commas inside regexes unambiguous.
+func (p *PluginContext) SetMetricCounterPatterns(patterns []string, logger log.Logger) {
+	if len(patterns) == 0 {
+		level.Error(logger).Log("msg", "metric_counter_pattern_1 not populated")
+		panic(1)
+	}
+	for _, v := range p.VariableLabels {
+		if v == "pattern" {
+			level.Error(logger).Log("msg", "metric_variable_labels cannot contain pattern with Counter Pattern")
+			panic(1)
+		}
+	}
+	p.MetricData.Counter.Patterns = patterns
+}
+
 // SetMetricSummaryObserveKey Set context metric_summary_observe_key
 // Required with Summary: Yes
 func (p *PluginContext) SetMetricSummaryObserveKey(k string, logger log.Logger) {
@@ -198,110 +652,1120 @@ func (p *PluginContext) SetMetricSummaryObserveKey(k string, logger log.Logger)
 
 }
 
-// SetMetricHistogramBucketType Set context metric_histogram_bucket_type
-// Required with Histogram: Yes
-// Values: Linear, Exponential
-func (p *PluginContext) SetMetricHistogramBucketType(c string, logger log.Logger) {
-	if len(c) != 0 {
-		p.MetricData.Histogram.BucketType = c
+// SetMetricDurationIDKey Set context metric_duration_id_key
+// Required: No
+// Note: When set, Summary and Histogram observe the seconds between start and end records sharing this ID
+func (p *PluginContext) SetMetricDurationIDKey(k string) {
+	p.MetricData.Correlation.IDKey = k
+}
+
+// SetMetricDurationEventKey Set context metric_duration_event_key
+// Required with metric_duration_id_key: Yes
+func (p *PluginContext) SetMetricDurationEventKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Correlation.EventKey = k
 	} else {
-		level.Error(logger).Log("msg", "metric_histogram_bucket_type not populated")
+		level.Error(logger).Log("msg", "metric_duration_event_key not populated")
 		panic(1)
 	}
 }
 
-// Linear Buckets
-
-// SetMetricHistogramLinearBucketsCount Set context metric_histogram_linear_buckets_count
-// Required with Histogram Linear: Yes
-func (p *PluginContext) SetMetricHistogramLinearBucketsCount(c string, logger log.Logger) {
-	if len(c) != 0 {
-		p.MetricData.Histogram.LinearBucketData.Count = c
+// SetMetricDurationStartValue Set context metric_duration_start_value
+// Required with metric_duration_id_key: Yes
+func (p *PluginContext) SetMetricDurationStartValue(v string, logger log.Logger) {
+	if len(v) != 0 {
+		p.MetricData.Correlation.StartValue = ConfigKeyQuoteTrim(v)
 	} else {
-		level.Error(logger).Log("msg", "metric_histogram_linear_buckets_count not populated")
+		level.Error(logger).Log("msg", "metric_duration_start_value not populated")
 		panic(1)
 	}
 }
 
-// SetMetricHistogramLinearBucketsWidth Set context metric_histogram_linear_buckets_width
-// Required with Histogram Linear: Yes
-func (p *PluginContext) SetMetricHistogramLinearBucketsWidth(w string, logger log.Logger) {
-	if len(w) != 0 {
-		p.MetricData.Histogram.LinearBucketData.Width = w
+// SetMetricDurationEndValue Set context metric_duration_end_value
+// Required with metric_duration_id_key: Yes
+func (p *PluginContext) SetMetricDurationEndValue(v string, logger log.Logger) {
+	if len(v) != 0 {
+		p.MetricData.Correlation.EndValue = ConfigKeyQuoteTrim(v)
 	} else {
-		level.Error(logger).Log("msg", "metric_histogram_linear_buckets_width not populated")
+		level.Error(logger).Log("msg", "metric_duration_end_value not populated")
 		panic(1)
 	}
 }
 
-// SetMetricHistogramLinearBucketsStart Set context metric_histogram_linear_buckets_start
-// Required with Histogram Linear: Yes
-func (p *PluginContext) SetMetricHistogramLinearBucketsStart(s string, logger log.Logger) {
-	if len(s) != 0 {
-		p.MetricData.Histogram.LinearBucketData.Start = s
-	} else {
-		level.Error(logger).Log("msg", "metric_histogram_linear_buckets_start not populated")
-		panic(1)
+// SetMetricDurationTTL Set context metric_duration_ttl
+// Required: No
+// Default: 1h
+func (p *PluginContext) SetMetricDurationTTL(t string, logger log.Logger) {
+	p.MetricData.Correlation.TTL = time.Hour
+	if len(t) != 0 {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_duration_ttl not a valid duration, defaulting to 1h.", "err", err)
+			return
+		}
+		p.MetricData.Correlation.TTL = d
 	}
 }
 
-// Exponential Buckets
+// SetMetricDurationMaxPending Set context metric_duration_max_pending
+// Required: No
+// Default: 10000
+func (p *PluginContext) SetMetricDurationMaxPending(m string, logger log.Logger) {
+	p.MetricData.Correlation.MaxPending = 10000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_duration_max_pending not a positive integer, defaulting to 10000.", "err", err)
+			return
+		}
+		p.MetricData.Correlation.MaxPending = v
+	}
+}
 
-// SetMetricHistogramExponentialBucketsCount Set context metric_histogram_exponential_buckets_count
-// Required with Histogram Exponential: Yes
-func (p *PluginContext) SetMetricHistogramExponentialBucketsCount(c string, logger log.Logger) {
-	if len(c) != 0 {
-		p.MetricData.Histogram.ExponentialBucketData.Count = c
+// SetMetricLagTimeKey Set context metric_lag_time_key
+// Required: No
+// Note: When unset, Lag is measured from the fluent bit record timestamp
+func (p *PluginContext) SetMetricLagTimeKey(k string) {
+	p.MetricData.Lag.TimeKey = k
+}
+
+// SetMetricLagTimeLayout Set context metric_lag_time_layout
+// Required: No
+// Values: Go time layout, Unix, UnixMilli
+// Default: RFC3339 (fractional seconds accepted)
+func (p *PluginContext) SetMetricLagTimeLayout(l string) {
+	if len(l) != 0 {
+		p.MetricData.Lag.TimeLayout = ConfigKeyQuoteTrim(l)
 	} else {
-		level.Error(logger).Log("msg", "metric_histogram_exponential_buckets_count not populated")
-		panic(1)
+		p.MetricData.Lag.TimeLayout = time.RFC3339Nano
 	}
 }
 
-// SetMetricHistogramExponentialBucketsFactor Set context metric_histogram_exponential_buckets_factor
-// Required with Histogram Exponential: Yes
-func (p *PluginContext) SetMetricHistogramExponentialBucketsFactor(w string, logger log.Logger) {
-	if len(w) != 0 {
-		p.MetricData.Histogram.ExponentialBucketData.Factor = w
+// SetMetricDistinctKey Set context metric_distinct_key
+// Required with Distinct: Yes
+func (p *PluginContext) SetMetricDistinctKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Distinct.Key = k
 	} else {
-		level.Error(logger).Log("msg", "metric_histogram_exponential_buckets_factor not populated")
+		level.Error(logger).Log("msg", "metric_distinct_key not populated")
 		panic(1)
 	}
 }
 
-// SetMetricHistogramExponentialBucketsStart Set context metric_histogram_exponential_buckets_start
-// Required with Histogram Exponential: Yes
-func (p *PluginContext) SetMetricHistogramExponentialBucketsStart(s string, logger log.Logger) {
-	if len(s) != 0 {
-		p.MetricData.Histogram.ExponentialBucketData.Start = s
-	} else {
-		level.Error(logger).Log("msg", "metric_histogram_exponential_buckets_start not populated")
-		panic(1)
+// SetMetricDistinctWindow Set context metric_distinct_window
+// Required: No
+// Default: 5m
+func (p *PluginContext) SetMetricDistinctWindow(w string, logger log.Logger) {
+	p.MetricData.Distinct.Window = 5 * time.Minute
+	if len(w) != 0 {
+		d, err := time.ParseDuration(w)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "metric_distinct_window not a valid duration of at least 1s, defaulting to 5m.", "err", err)
+			return
+		}
+		p.MetricData.Distinct.Window = d
 	}
 }
 
-// SetMetricHistogramObserveKey Set context metric_histogram_observe_key
-// Required with Histogram: Yes
-func (p *PluginContext) SetMetricHistogramObserveKey(k string, logger log.Logger) {
+// SetMetricDistinctPrecision Set context metric_distinct_precision
+// Required: No
+// Values: 4 - 16
+// Default: 12
+// Note: Memory per label set is 5 x 2^precision bytes, standard error is about 1.04 / sqrt(2^precision)
+func (p *PluginContext) SetMetricDistinctPrecision(v string, logger log.Logger) {
+	p.MetricData.Distinct.Precision = 12
+	if len(v) != 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 4 || n > 16 {
+			level.Error(logger).Log("msg", "metric_distinct_precision not an integer between 4 and 16, defaulting to 12.", "err", err)
+			return
+		}
+		p.MetricData.Distinct.Precision = uint8(n)
+	}
+}
+
+// SetMetricTopKKey Set context metric_topk_key
+// Required with TopK: Yes
+func (p *PluginContext) SetMetricTopKKey(k string, logger log.Logger) {
 	if len(k) != 0 {
-		p.MetricData.Histogram.ObserveKey = k
+		p.MetricData.TopK.Key = k
 	} else {
-		level.Error(logger).Log("msg", "metric_histogram_observe_key not populated")
+		level.Error(logger).Log("msg", "metric_topk_key not populated")
 		panic(1)
 	}
+}
+
+// SetMetricTopKK Set context metric_topk_k
+// Required: No
+// Default: 10
+func (p *PluginContext) SetMetricTopKK(v string, logger log.Logger) {
+	p.MetricData.TopK.K = 10
+	if len(v) != 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			level.Error(logger).Log("msg", "metric_topk_k not a positive integer, defaulting to 10.", "err", err)
+			return
+		}
+		p.MetricData.TopK.K = n
+	}
+}
 
+// SetMetricTopKCapacity Set context metric_topk_capacity
+// Required: No
+// Default: 10 x metric_topk_k
+// Note: Number of counters kept by the Space-Saving sketch, never less than metric_topk_k
+func (p *PluginContext) SetMetricTopKCapacity(v string, logger log.Logger) {
+	p.MetricData.TopK.Capacity = 10 * p.MetricData.TopK.K
+	if len(v) != 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.MetricData.TopK.K {
+			level.Error(logger).Log("msg", "metric_topk_capacity not an integer of at least metric_topk_k, using the default.", "err", err)
+			return
+		}
+		p.MetricData.TopK.Capacity = n
+	}
 }
 
-// SetMetricGaugeMethod Set context metric_gauge_method
-// Required with Gauge: Yes
-// Values: Set, Add, Sub, Inc, Dec
-func (p *PluginContext) SetMetricGaugeMethod(s string, logger log.Logger) {
-	if len(s) != 0 {
-		p.MetricData.Gauge.Method = s
-	} else {
-		level.Error(logger).Log("msg", "metric_gauge_method not populated")
-		panic(1)
+// SetMetricTopKWindow Set context metric_topk_window
+// Required: No
+// Default: 1m
+func (p *PluginContext) SetMetricTopKWindow(w string, logger log.Logger) {
+	p.MetricData.TopK.Window = time.Minute
+	if len(w) != 0 {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			level.Error(logger).Log("msg", "metric_topk_window not a valid duration, defaulting to 1m.", "err", err)
+			return
+		}
+		p.MetricData.TopK.Window = d
+	}
+}
+
+// SetMetricTopKLabel Set context metric_topk_label
+// Required: No
+// Default: value
+func (p *PluginContext) SetMetricTopKLabel(l string, logger log.Logger) {
+	p.MetricData.TopK.Label = "value"
+	if len(l) != 0 {
+		p.MetricData.TopK.Label = l
+	}
+	for _, v := range p.VariableLabels {
+		if v == p.MetricData.TopK.Label {
+			level.Error(logger).Log("msg", "metric_topk_label clashes with metric_variable_labels", "label", v)
+			panic(1)
+		}
+	}
+}
+
+// SetMetricWindowObserveKey Set context metric_window_observe_key
+// Required with Window: Yes
+func (p *PluginContext) SetMetricWindowObserveKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Window.ObserveKey = k
+	} else {
+		level.Error(logger).Log("msg", "metric_window_observe_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricWindowMode Set context metric_window_mode
+// Required: No
+// Values: Tumbling, Sliding
+// Default: Tumbling
+func (p *PluginContext) SetMetricWindowMode(m string, logger log.Logger) {
+	switch m {
+	case "":
+		p.MetricData.Window.Mode = "Tumbling"
+	case "Tumbling", "Sliding":
+		p.MetricData.Window.Mode = m
+	default:
+		level.Error(logger).Log("msg", "Unknown metric_window_mode", "mode", m)
+		panic(1)
+	}
+}
+
+// SetMetricWindowLength Set context metric_window_length
+// Required: No
+// Default: 1m
+func (p *PluginContext) SetMetricWindowLength(l string, logger log.Logger) {
+	p.MetricData.Window.Length = time.Minute
+	if len(l) != 0 {
+		d, err := time.ParseDuration(l)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "metric_window_length not a valid duration of at least 1s, defaulting to 1m.", "err", err)
+			return
+		}
+		p.MetricData.Window.Length = d
+	}
+}
+
+// SetMetricWindowStep Set context metric_window_step
+// Required: No
+// Default: metric_window_length / 6 with Sliding, metric_window_length with Tumbling
+// Note: Must divide metric_window_length evenly
+func (p *PluginContext) SetMetricWindowStep(s string, logger log.Logger) {
+	if p.MetricData.Window.Mode == "Tumbling" {
+		p.MetricData.Window.Step = p.MetricData.Window.Length
+		return
+	}
+
+	p.MetricData.Window.Step = p.MetricData.Window.Length / 6
+	if len(s) != 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || p.MetricData.Window.Length%d != 0 {
+			level.Error(logger).Log("msg", "metric_window_step must divide metric_window_length, defaulting to a sixth of it.", "err", err)
+			return
+		}
+		p.MetricData.Window.Step = d
+	}
+}
+
+// SetMetricSketchObserveKey Set context metric_sketch_observe_key
+// Required with Sketch: Yes
+func (p *PluginContext) SetMetricSketchObserveKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Sketch.ObserveKey = k
+	} else {
+		level.Error(logger).Log("msg", "metric_sketch_observe_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricSketchRelativeAccuracy Set context metric_sketch_relative_accuracy
+// Required: No
+// Default: 0.01
+func (p *PluginContext) SetMetricSketchRelativeAccuracy(a string, logger log.Logger) {
+	p.MetricData.Sketch.RelativeAccuracy = 0.01
+	if len(a) != 0 {
+		v, err := strconv.ParseFloat(a, 64)
+		if err != nil || v <= 0 || v >= 1 {
+			level.Error(logger).Log("msg", "metric_sketch_relative_accuracy not between 0 and 1, defaulting to 0.01.", "err", err)
+			return
+		}
+		p.MetricData.Sketch.RelativeAccuracy = v
+	}
+}
+
+// SetMetricSketchQuantiles Set context metric_sketch_quantiles
+// Required: No
+// Default: 0.5, 0.9, 0.99
+func (p *PluginContext) SetMetricSketchQuantiles(q string, logger log.Logger) {
+	p.MetricData.Sketch.Quantiles = []float64{0.5, 0.9, 0.99}
+	if len(q) != 0 {
+		var quantiles []float64
+		for _, s := range strings.Split(StripWhitespace(q), ",") {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v < 0 || v > 1 {
+				level.Error(logger).Log("msg", "metric_sketch_quantiles entry not between 0 and 1", "entry", s, "err", err)
+				panic(1)
+			}
+			quantiles = append(quantiles, v)
+		}
+		p.MetricData.Sketch.Quantiles = quantiles
+	}
+}
+
+// SetMetricSketchExportURL Set context metric_sketch_export_url
+// Required: No
+// Note: Sketches of the observations since the previous export are POSTed as JSON to this URL
+func (p *PluginContext) SetMetricSketchExportURL(u string) {
+	p.MetricData.Sketch.ExportURL = u
+}
+
+// SetMetricSketchExportInterval Set context metric_sketch_export_interval
+// Required: No
+// Default: 60s
+func (p *PluginContext) SetMetricSketchExportInterval(i string, logger log.Logger) {
+	p.MetricData.Sketch.ExportInterval = time.Minute
+	if len(i) != 0 {
+		d, err := time.ParseDuration(i)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "metric_sketch_export_interval not a valid duration of at least 1s, defaulting to 60s.", "err", err)
+			return
+		}
+		p.MetricData.Sketch.ExportInterval = d
+	}
+}
+
+// SetMetricTemplateKey Set context metric_template_key
+// Required with Template: Yes
+func (p *PluginContext) SetMetricTemplateKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Template.Key = k
+	} else {
+		level.Error(logger).Log("msg", "metric_template_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricTemplateMax Set context metric_template_max
+// Required: No
+// Default: 500
+// Note: Hard cap on discovered templates, records matching none once reached are counted as other
+func (p *PluginContext) SetMetricTemplateMax(m string, logger log.Logger) {
+	p.MetricData.Template.MaxTemplates = 500
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_template_max not a positive integer, defaulting to 500.", "err", err)
+			return
+		}
+		p.MetricData.Template.MaxTemplates = v
+	}
+}
+
+// SetMetricTemplateSimilarity Set context metric_template_similarity
+// Required: No
+// Default: 0.4
+func (p *PluginContext) SetMetricTemplateSimilarity(s string, logger log.Logger) {
+	p.MetricData.Template.Similarity = 0.4
+	if len(s) != 0 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 || v > 1 {
+			level.Error(logger).Log("msg", "metric_template_similarity not between 0 and 1, defaulting to 0.4.", "err", err)
+			return
+		}
+		p.MetricData.Template.Similarity = v
+	}
+}
+
+// SetMetricTemplateDepth Set context metric_template_depth
+// Required: No
+// Default: 4
+func (p *PluginContext) SetMetricTemplateDepth(d string, logger log.Logger) {
+	p.MetricData.Template.Depth = 4
+	if len(d) != 0 {
+		v, err := strconv.Atoi(d)
+		if err != nil || v < 3 {
+			level.Error(logger).Log("msg", "metric_template_depth not an integer of at least 3, defaulting to 4.", "err", err)
+			return
+		}
+		p.MetricData.Template.Depth = v
+	}
+}
+
+// SetMetricTemplateLabel Set context metric_template_label
+// Required: No
+// Default: template_id
+func (p *PluginContext) SetMetricTemplateLabel(l string, logger log.Logger) {
+	p.MetricData.Template.Label = "template_id"
+	if len(l) != 0 {
+		p.MetricData.Template.Label = l
+	}
+	if p.MetricData.Template.Label == "template" {
+		level.Error(logger).Log("msg", "metric_template_label cannot be template, the label holding the text on the info metric")
+		panic(1)
+	}
+	if _, ok := p.ConstantLabels["template"]; ok {
+		level.Error(logger).Log("msg", "metric_constant_labels cannot hold template with the Template metric type")
+		panic(1)
+	}
+	for _, v := range p.VariableLabels {
+		if v == p.MetricData.Template.Label {
+			level.Error(logger).Log("msg", "metric_template_label clashes with metric_variable_labels", "label", v)
+			panic(1)
+		}
+	}
+}
+
+// SetMetricSLOBadCondition Set context metric_slo_bad_condition
+// Required with SLO: Yes
+// Note: Ex. status_code >= 500 || latency > 0.3
+func (p *PluginContext) SetMetricSLOBadCondition(c string, logger log.Logger) {
+	if len(c) == 0 {
+		level.Error(logger).Log("msg", "metric_slo_bad_condition not populated")
+		panic(1)
+	}
+	cond, err := ParseRecordCondition(TrimConditionQuotes(c))
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid metric_slo_bad_condition", "err", err)
+		panic(err)
+	}
+	p.MetricData.SLO.BadCondition = cond
+}
+
+// SetMetricSLOIgnoreCondition Set context metric_slo_ignore_condition
+// Required: No
+// Note: Records matching it count as ignored and never reach the error ratio, Ex. status_code =~ ^4
+func (p *PluginContext) SetMetricSLOIgnoreCondition(c string, logger log.Logger) {
+	if len(c) == 0 {
+		return
+	}
+	cond, err := ParseRecordCondition(TrimConditionQuotes(c))
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid metric_slo_ignore_condition", "err", err)
+		panic(err)
+	}
+	p.MetricData.SLO.IgnoreCondition = cond
+}
+
+// SetMetricSLOObjective Set context metric_slo_objective
+// Required: No
+// Default: 0.99
+func (p *PluginContext) SetMetricSLOObjective(o string, logger log.Logger) {
+	p.MetricData.SLO.Objective = 0.99
+	if len(o) != 0 {
+		v, err := strconv.ParseFloat(o, 64)
+		if err != nil || v <= 0 || v >= 1 {
+			level.Error(logger).Log("msg", "metric_slo_objective not between 0 and 1, defaulting to 0.99.", "err", err)
+			return
+		}
+		p.MetricData.SLO.Objective = v
+	}
+}
+
+// SetMetricSLOWindows Set context metric_slo_windows
+// Required: No
+// Default: 5m, 1h, 6h
+func (p *PluginContext) SetMetricSLOWindows(w string, logger log.Logger) {
+	if len(w) == 0 {
+		w = "5m,1h,6h"
+	}
+	p.MetricData.SLO.Windows = nil
+	for _, s := range strings.Split(StripWhitespace(w), ",") {
+		d, err := model.ParseDuration(s)
+		if err != nil || time.Duration(d) < 10*time.Second {
+			level.Error(logger).Log("msg", "metric_slo_windows entry not a duration of at least 10s", "entry", s, "err", err)
+			panic(1)
+		}
+		p.MetricData.SLO.Windows = append(p.MetricData.SLO.Windows, time.Duration(d))
+	}
+}
+
+// SetMetricApdexObserveKey Set context metric_apdex_observe_key
+// Required with Apdex: Yes
+func (p *PluginContext) SetMetricApdexObserveKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Apdex.ObserveKey = k
+	} else {
+		level.Error(logger).Log("msg", "metric_apdex_observe_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricApdexThreshold Set context metric_apdex_threshold
+// Required with Apdex: Yes
+// Note: T, in the unit of metric_apdex_observe_key
+func (p *PluginContext) SetMetricApdexThreshold(t string, logger log.Logger) {
+	v, err := strconv.ParseFloat(t, 64)
+	if err != nil || v <= 0 {
+		level.Error(logger).Log("msg", "metric_apdex_threshold not a positive number", "err", err)
+		panic(1)
+	}
+	p.MetricData.Apdex.Threshold = v
+}
+
+// SetMetricApdexWindow Set context metric_apdex_window
+// Required: No
+// Default: 5m
+func (p *PluginContext) SetMetricApdexWindow(w string, logger log.Logger) {
+	p.MetricData.Apdex.Window = 5 * time.Minute
+	if len(w) != 0 {
+		d, err := time.ParseDuration(w)
+		if err != nil || d < 10*time.Second {
+			level.Error(logger).Log("msg", "metric_apdex_window not a valid duration of at least 10s, defaulting to 5m.", "err", err)
+			return
+		}
+		p.MetricData.Apdex.Window = d
+	}
+}
+
+// SetMetricHeartbeatInterval Set context metric_heartbeat_interval
+// Required with Heartbeat: Yes
+// Note: Longest silence after which a label set is reported down, Ex. 5m
+func (p *PluginContext) SetMetricHeartbeatInterval(i string, logger log.Logger) {
+	d, err := time.ParseDuration(i)
+	if err != nil || d < time.Second {
+		level.Error(logger).Log("msg", "metric_heartbeat_interval not a valid duration of at least 1s", "err", err)
+		panic(1)
+	}
+	p.MetricData.Heartbeat.Interval = d
+}
+
+// SetMetricHeartbeatExpected Set context metric_heartbeat_expected
+// Required: No
+// Note: JSON array of label sets watched from startup, Ex. [{"host":"web1"},{"host":"web2"}]
+func (p *PluginContext) SetMetricHeartbeatExpected(e string, logger log.Logger) {
+	if len(e) != 0 {
+		err := json.Unmarshal([]byte(e), &p.MetricData.Heartbeat.Expected)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_heartbeat_expected JSON issue", "input", e, "err", err)
+			panic(err)
+		}
+	}
+}
+
+// SetMetricHeartbeatLearn Set context metric_heartbeat_learn
+// Required: No
+// Default: true
+func (p *PluginContext) SetMetricHeartbeatLearn(l string, logger log.Logger) {
+	p.MetricData.Heartbeat.Learn = true
+	if len(l) != 0 {
+		v, err := strconv.ParseBool(l)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_heartbeat_learn not a boolean, defaulting to true.", "err", err)
+			return
+		}
+		p.MetricData.Heartbeat.Learn = v
+	}
+}
+
+// SetMetricHeartbeatForgetAfter Set context metric_heartbeat_forget_after
+// Required: No
+// Note: Learned label sets silent for longer are dropped.  Unset keeps them, and reports them down, forever.
+func (p *PluginContext) SetMetricHeartbeatForgetAfter(f string, logger log.Logger) {
+	if len(f) != 0 {
+		d, err := time.ParseDuration(f)
+		if err != nil || d < p.MetricData.Heartbeat.Interval {
+			level.Error(logger).Log("msg", "metric_heartbeat_forget_after not a valid duration of at least metric_heartbeat_interval, learned label sets are kept.", "err", err)
+			return
+		}
+		p.MetricData.Heartbeat.ForgetAfter = d
+	}
+}
+
+// SetMetricSequenceKey Set context metric_sequence_key
+// Required with Sequence: Yes
+func (p *PluginContext) SetMetricSequenceKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Sequence.Key = k
+	} else {
+		level.Error(logger).Log("msg", "metric_sequence_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricSequenceStreamKey Set context metric_sequence_stream_key
+// Required: No
+// Note: Records sharing the variable labels and this field's value form one sequence
+func (p *PluginContext) SetMetricSequenceStreamKey(k string) {
+	p.MetricData.Sequence.StreamKey = k
+}
+
+// SetMetricSequenceWindow Set context metric_sequence_window
+// Required: No
+// Default: 1024
+// Note: Values up to this far behind the highest seen are late or duplicates, missing ones older than that are gaps
+func (p *PluginContext) SetMetricSequenceWindow(w string, logger log.Logger) {
+	p.MetricData.Sequence.Window = 1024
+	if len(w) != 0 {
+		v, err := strconv.Atoi(w)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_sequence_window not a positive integer, defaulting to 1024.", "err", err)
+			return
+		}
+		p.MetricData.Sequence.Window = v
+	}
+}
+
+// SetMetricSequenceMaxStreams Set context metric_sequence_max_streams
+// Required: No
+// Default: 10000
+func (p *PluginContext) SetMetricSequenceMaxStreams(m string, logger log.Logger) {
+	p.MetricData.Sequence.MaxStreams = 10000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_sequence_max_streams not a positive integer, defaulting to 10000.", "err", err)
+			return
+		}
+		p.MetricData.Sequence.MaxStreams = v
+	}
+}
+
+// SetMetricPassthroughKey Set context metric_passthrough_key
+// Required with Passthrough: Yes
+func (p *PluginContext) SetMetricPassthroughKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Passthrough.Key = k
+	} else {
+		level.Error(logger).Log("msg", "metric_passthrough_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricPassthroughTagLabels Set context metric_passthrough_tag_labels
+// Required: No
+// Note: JSON object of labels whose values may hold $TAG or $TAG[n], Ex. {"batch":"$TAG[1]"}.  job is set by the push itself and is rejected.
+func (p *PluginContext) SetMetricPassthroughTagLabels(l string, logger log.Logger) {
+	if len(l) != 0 {
+		err := json.Unmarshal([]byte(l), &p.MetricData.Passthrough.TagLabels)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_passthrough_tag_labels JSON issue", "input", l, "err", err)
+			panic(err)
+		}
+	}
+	for k := range p.MetricData.Passthrough.TagLabels {
+		if pushGroupingLabel(k) {
+			level.Error(logger).Log("msg", "metric_passthrough_tag_labels cannot hold a label the push gateway groups by", "label", k)
+			panic(1)
+		}
+	}
+	for k := range p.ConstantLabels {
+		if pushGroupingLabel(k) {
+			level.Error(logger).Log("msg", "metric_constant_labels cannot hold a label the push gateway groups by with the Passthrough metric type", "label", k)
+			panic(1)
+		}
+	}
+}
+
+// SetMetricPassthroughTTL Set context metric_passthrough_ttl
+// Required: No
+// Note: Series not logged again within the TTL are dropped.  Unset keeps them for the life of the plugin.
+func (p *PluginContext) SetMetricPassthroughTTL(t string, logger log.Logger) {
+	if len(t) != 0 {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			level.Error(logger).Log("msg", "metric_passthrough_ttl not a positive duration, series are kept.", "err", err)
+			return
+		}
+		p.MetricData.Passthrough.TTL = d
+	}
+}
+
+// SetMetricPassthroughMaxSeries Set context metric_passthrough_max_series
+// Required: No
+// Default: 10000
+func (p *PluginContext) SetMetricPassthroughMaxSeries(m string, logger log.Logger) {
+	p.MetricData.Passthrough.MaxSeries = 10000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_passthrough_max_series not a positive integer, defaulting to 10000.", "err", err)
+			return
+		}
+		p.MetricData.Passthrough.MaxSeries = v
+	}
+}
+
+// SetMetricStatsDKey Set context metric_statsd_key
+// Required with StatsD: Yes
+func (p *PluginContext) SetMetricStatsDKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.StatsD.Key = k
+	} else {
+		level.Error(logger).Log("msg", "metric_statsd_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricStatsDMappings Set context metric_statsd_mapping_1 ... metric_statsd_mapping_N
+// Required: No
+// Note: Each key holds one MATCH NAME [label=value,...] rule, tried in order.  Numbering stops at the first missing key.
+func (p *PluginContext) SetMetricStatsDMappings(mappings []string, logger log.Logger) {
+	for _, m := range mappings {
+		mapping, err := ParseStatsDMapping(ConfigKeyQuoteTrim(m))
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid statsd mapping", "err", err)
+			panic(err)
+		}
+		p.MetricData.StatsD.Mappings = append(p.MetricData.StatsD.Mappings, mapping)
+	}
+}
+
+// SetMetricStatsDDropUnmapped Set context metric_statsd_drop_unmapped
+// Required: No
+// Default: false
+func (p *PluginContext) SetMetricStatsDDropUnmapped(d string, logger log.Logger) {
+	if len(d) != 0 {
+		v, err := strconv.ParseBool(d)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_statsd_drop_unmapped not a boolean, defaulting to false.", "err", err)
+			return
+		}
+		p.MetricData.StatsD.DropUnmapped = v
+	}
+}
+
+// SetMetricStatsDBuckets Set context metric_statsd_buckets
+// Required: No
+// Default: Prometheus client default buckets
+// Note: Comma separated upper bounds of the histograms made from timers, in seconds
+func (p *PluginContext) SetMetricStatsDBuckets(b string, logger log.Logger) {
+	p.MetricData.StatsD.Buckets = prometheus.DefBuckets
+	if len(b) != 0 {
+		buckets, err := ParseBucketList(b)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_statsd_buckets entry not a number", "err", err)
+			panic(1)
+		}
+		p.MetricData.StatsD.Buckets = buckets
+	}
+}
+
+// ParseBucketList Parse comma separated histogram upper bounds into ascending order
+func ParseBucketList(b string) ([]float64, error) {
+	var buckets []float64
+	for _, s := range strings.Split(StripWhitespace(b), ",") {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, v)
+	}
+	sort.Float64s(buckets)
+	return buckets, nil
+}
+
+// SetMetricStatsDMaxSeries Set context metric_statsd_max_series
+// Required: No
+// Default: 10000
+func (p *PluginContext) SetMetricStatsDMaxSeries(m string, logger log.Logger) {
+	p.MetricData.StatsD.MaxSeries = 10000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_statsd_max_series not a positive integer, defaulting to 10000.", "err", err)
+			return
+		}
+		p.MetricData.StatsD.MaxSeries = v
+	}
+}
+
+// SetMetricEMFKey Set context metric_emf_key
+// Required: No
+// Note: Field holding the EMF record as a JSON string.  When unset the record itself is read as EMF.
+func (p *PluginContext) SetMetricEMFKey(k string) {
+	p.MetricData.EMF.Key = k
+}
+
+// SetMetricEMFBuckets Set context metric_emf_buckets
+// Required: No
+// Default: Prometheus client default buckets
+// Note: Comma separated upper bounds, in seconds, of the histograms made from time units
+func (p *PluginContext) SetMetricEMFBuckets(b string, logger log.Logger) {
+	p.MetricData.EMF.Buckets = prometheus.DefBuckets
+	if len(b) != 0 {
+		buckets, err := ParseBucketList(b)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_emf_buckets entry not a number", "err", err)
+			panic(1)
+		}
+		p.MetricData.EMF.Buckets = buckets
+	}
+}
+
+// SetMetricEMFMaxSeries Set context metric_emf_max_series
+// Required: No
+// Default: 10000
+func (p *PluginContext) SetMetricEMFMaxSeries(m string, logger log.Logger) {
+	p.MetricData.EMF.MaxSeries = 10000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_emf_max_series not a positive integer, defaulting to 10000.", "err", err)
+			return
+		}
+		p.MetricData.EMF.MaxSeries = v
+	}
+}
+
+// SetMetricEMFMaxSeriesPerMetric Set context metric_emf_max_series_per_metric
+// Required: No
+// Default: 1000
+func (p *PluginContext) SetMetricEMFMaxSeriesPerMetric(m string, logger log.Logger) {
+	p.MetricData.EMF.MaxSeriesPerMetric = 1000
+	if len(m) != 0 {
+		v, err := strconv.Atoi(m)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_emf_max_series_per_metric not a positive integer, defaulting to 1000.", "err", err)
+			return
+		}
+		p.MetricData.EMF.MaxSeriesPerMetric = v
+	}
+}
+
+// SetMetricAnomalyInterval Set context metric_anomaly_interval
+// Required: No
+// Note: Enables anomaly detection for any metric type, Ex. 1m
+func (p *PluginContext) SetMetricAnomalyInterval(i string, logger log.Logger) {
+	if len(i) != 0 {
+		d, err := time.ParseDuration(i)
+		if err != nil || d < time.Second {
+			level.Error(logger).Log("msg", "metric_anomaly_interval not a valid duration of at least 1s", "err", err)
+			panic(1)
+		}
+		p.MetricData.Anomaly.Interval = d
+	}
+}
+
+// SetMetricAnomalyObserveKey Set context metric_anomaly_observe_key
+// Required: No
+// Note: When unset the rate of records is tracked, otherwise the per interval sum of this field
+func (p *PluginContext) SetMetricAnomalyObserveKey(k string) {
+	p.MetricData.Anomaly.ObserveKey = k
+}
+
+// SetMetricAnomalyAlpha Set context metric_anomaly_alpha
+// Required: No
+// Default: 0.1
+func (p *PluginContext) SetMetricAnomalyAlpha(a string, logger log.Logger) {
+	p.MetricData.Anomaly.Alpha = 0.1
+	if len(a) != 0 {
+		v, err := strconv.ParseFloat(a, 64)
+		if err != nil || v <= 0 || v >= 1 {
+			level.Error(logger).Log("msg", "metric_anomaly_alpha not between 0 and 1, defaulting to 0.1.", "err", err)
+			return
+		}
+		p.MetricData.Anomaly.Alpha = v
+	}
+}
+
+// SetMetricAnomalyThreshold Set context metric_anomaly_threshold
+// Required: No
+// Default: 3
+func (p *PluginContext) SetMetricAnomalyThreshold(t string, logger log.Logger) {
+	p.MetricData.Anomaly.Threshold = 3
+	if len(t) != 0 {
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil || v <= 0 {
+			level.Error(logger).Log("msg", "metric_anomaly_threshold not a positive number, defaulting to 3.", "err", err)
+			return
+		}
+		p.MetricData.Anomaly.Threshold = v
+	}
+}
+
+// SetMetricAnomalyEvents Set context metric_anomaly_events
+// Required: No
+// Values: None, Log
+// Default: None
+func (p *PluginContext) SetMetricAnomalyEvents(e string, logger log.Logger) {
+	switch e {
+	case "":
+		p.MetricData.Anomaly.Events = "None"
+	case "None", "Log":
+		p.MetricData.Anomaly.Events = e
+	default:
+		level.Error(logger).Log("msg", "Unknown metric_anomaly_events", "events", e)
+		panic(1)
+	}
+}
+
+// SetMetricHistogramBucketType Set context metric_histogram_bucket_type
+// Required with Histogram: Yes
+// Values: Linear, Exponential
+func (p *PluginContext) SetMetricHistogramBucketType(c string, logger log.Logger) {
+	if len(c) != 0 {
+		p.MetricData.Histogram.BucketType = c
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_bucket_type not populated")
+		panic(1)
+	}
+}
+
+// Linear Buckets
+
+// SetMetricHistogramLinearBucketsCount Set context metric_histogram_linear_buckets_count
+// Required with Histogram Linear: Yes
+func (p *PluginContext) SetMetricHistogramLinearBucketsCount(c string, logger log.Logger) {
+	if len(c) != 0 {
+		p.MetricData.Histogram.LinearBucketData.Count = c
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_linear_buckets_count not populated")
+		panic(1)
+	}
+}
+
+// SetMetricHistogramLinearBucketsWidth Set context metric_histogram_linear_buckets_width
+// Required with Histogram Linear: Yes
+func (p *PluginContext) SetMetricHistogramLinearBucketsWidth(w string, logger log.Logger) {
+	if len(w) != 0 {
+		p.MetricData.Histogram.LinearBucketData.Width = w
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_linear_buckets_width not populated")
+		panic(1)
+	}
+}
+
+// SetMetricHistogramLinearBucketsStart Set context metric_histogram_linear_buckets_start
+// Required with Histogram Linear: Yes
+func (p *PluginContext) SetMetricHistogramLinearBucketsStart(s string, logger log.Logger) {
+	if len(s) != 0 {
+		p.MetricData.Histogram.LinearBucketData.Start = s
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_linear_buckets_start not populated")
+		panic(1)
+	}
+}
+
+// Exponential Buckets
+
+// SetMetricHistogramExponentialBucketsCount Set context metric_histogram_exponential_buckets_count
+// Required with Histogram Exponential: Yes
+func (p *PluginContext) SetMetricHistogramExponentialBucketsCount(c string, logger log.Logger) {
+	if len(c) != 0 {
+		p.MetricData.Histogram.ExponentialBucketData.Count = c
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_exponential_buckets_count not populated")
+		panic(1)
+	}
+}
+
+// SetMetricHistogramExponentialBucketsFactor Set context metric_histogram_exponential_buckets_factor
+// Required with Histogram Exponential: Yes
+func (p *PluginContext) SetMetricHistogramExponentialBucketsFactor(w string, logger log.Logger) {
+	if len(w) != 0 {
+		p.MetricData.Histogram.ExponentialBucketData.Factor = w
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_exponential_buckets_factor not populated")
+		panic(1)
+	}
+}
+
+// SetMetricHistogramExponentialBucketsStart Set context metric_histogram_exponential_buckets_start
+// Required with Histogram Exponential: Yes
+func (p *PluginContext) SetMetricHistogramExponentialBucketsStart(s string, logger log.Logger) {
+	if len(s) != 0 {
+		p.MetricData.Histogram.ExponentialBucketData.Start = s
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_exponential_buckets_start not populated")
+		panic(1)
+	}
+}
+
+// SetMetricHistogramObserveKey Set context metric_histogram_observe_key
+// Required with Histogram: Yes
+func (p *PluginContext) SetMetricHistogramObserveKey(k string, logger log.Logger) {
+	if len(k) != 0 {
+		p.MetricData.Histogram.ObserveKey = k
+	} else {
+		level.Error(logger).Log("msg", "metric_histogram_observe_key not populated")
+		panic(1)
+	}
+
+}
+
+// SetMetricHistogramMode Set context metric_histogram_mode
+// Required: No
+// Values: Observe, Import
+// Default: Observe
+func (p *PluginContext) SetMetricHistogramMode(m string, logger log.Logger) {
+	switch m {
+	case "":
+		p.MetricData.Histogram.Mode = "Observe"
+	case "Observe", "Import":
+		p.MetricData.Histogram.Mode = m
+	default:
+		level.Error(logger).Log("msg", "Unknown metric_histogram_mode", "mode", m)
+		panic(1)
+	}
+}
+
+// SetMetricHistogramImportBucketsKey Set context metric_histogram_import_buckets_key
+// Required: No
+// Note: Field holding an object keyed by bound, or an array matching metric_histogram_bucket_type.  When unset, top level keys starting with metric_histogram_import_bucket_prefix are read.
+func (p *PluginContext) SetMetricHistogramImportBucketsKey(k string) {
+	p.MetricData.Histogram.ImportBucketsKey = k
+}
+
+// SetMetricHistogramImportBucketPrefix Set context metric_histogram_import_bucket_prefix
+// Required: No
+// Default: le_
+func (p *PluginContext) SetMetricHistogramImportBucketPrefix(x string) {
+	if len(x) != 0 {
+		p.MetricData.Histogram.ImportBucketPrefix = ConfigKeyQuoteTrim(x)
+	} else {
+		p.MetricData.Histogram.ImportBucketPrefix = "le_"
+	}
+}
+
+// SetMetricHistogramImportBucketCounts Set context metric_histogram_import_bucket_counts
+// Required: No
+// Values: Cumulative, PerBucket
+// Default: Cumulative
+func (p *PluginContext) SetMetricHistogramImportBucketCounts(c string, logger log.Logger) {
+	switch c {
+	case "":
+		p.MetricData.Histogram.ImportBucketCounts = "Cumulative"
+	case "Cumulative", "PerBucket":
+		p.MetricData.Histogram.ImportBucketCounts = c
+	default:
+		level.Error(logger).Log("msg", "Unknown metric_histogram_import_bucket_counts", "counts", c)
+		panic(1)
+	}
+}
+
+// SetMetricHistogramImportSumKey Set context metric_histogram_import_sum_key
+// Required: No
+// Note: When unset the sum stays 0
+func (p *PluginContext) SetMetricHistogramImportSumKey(k string) {
+	p.MetricData.Histogram.ImportSumKey = k
+}
+
+// SetMetricHistogramImportCountKey Set context metric_histogram_import_count_key
+// Required: No
+// Note: When unset the count is taken from the +Inf bucket, or the highest bound
+func (p *PluginContext) SetMetricHistogramImportCountKey(k string) {
+	p.MetricData.Histogram.ImportCountKey = k
+}
+
+// SetMetricGaugeMethod Set context metric_gauge_method
+// Required with Gauge: Yes
+// Values: Set, Add, Sub, Inc, Dec, Max, Min, SetToRecordTime, SetToCurrentTime
+func (p *PluginContext) SetMetricGaugeMethod(s string, logger log.Logger) {
+	if len(s) != 0 {
+		p.MetricData.Gauge.Method = s
+	} else {
+		level.Error(logger).Log("msg", "metric_gauge_method not populated")
+		panic(1)
+	}
+}
+
+// SetMetricGaugeMethodKey Set context metric_gauge_method_key
+// Required: No
+// Note: When set, the gauge method is chosen per record from the value of this field using metric_gauge_method_map
+func (p *PluginContext) SetMetricGaugeMethodKey(s string) {
+	p.MetricData.Gauge.MethodKey = s
+}
+
+// SetMetricGaugeMethodMap Set context metric_gauge_method_map
+// Required with metric_gauge_method_key: Yes
+// Values: Comma separated value:method pairs, Ex. open:Inc,close:Dec,reset:Set0
+func (p *PluginContext) SetMetricGaugeMethodMap(s string, logger log.Logger) {
+	if len(s) == 0 {
+		level.Error(logger).Log("msg", "metric_gauge_method_map not populated")
+		panic(1)
+	}
+
+	p.MetricData.Gauge.MethodMap = make(map[string]string)
+	for _, pair := range strings.Split(StripWhitespace(s), ",") {
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			level.Error(logger).Log("msg", "metric_gauge_method_map entry is not value:method", "entry", pair)
+			panic(1)
+		}
+		method := pair[i+1:]
+		switch method {
+		case "Set", "Add", "Sub", "Inc", "Dec", "SetToRecordTime", "SetToCurrentTime":
+		default:
+			if _, ok := GaugeSetConstant(method); !ok {
+				level.Error(logger).Log("msg", "Unsupported method in metric_gauge_method_map", "method", method)
+				panic(1)
+			}
+		}
+		p.MetricData.Gauge.MethodMap[pair[:i]] = method
+	}
+}
+
+// GaugeSetConstant Parse methods of the form Set<value>, Ex. Set0, into the constant to set.  NaN is refused.
+func GaugeSetConstant(method string) (float64, bool) {
+	if len(method) <= 3 || !strings.HasPrefix(method, "Set") {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(method[3:], 64)
+	return v, err == nil && !math.IsNaN(v)
+}
+
+// GaugeMethod Resolve the gauge method for a record, consulting metric_gauge_method_key when configured
+func (p *PluginContext) GaugeMethod(fields *RecordFields) string {
+	if len(p.MetricData.Gauge.MethodKey) == 0 {
+		return p.MetricData.Gauge.Method
+	}
+	if m, ok := p.MetricData.Gauge.MethodMap[fmt.Sprintf("%v", fields.Get(p.MetricData.Gauge.MethodKey))]; ok {
+		return m
 	}
+	return p.MetricData.Gauge.Method
 }
 
 // SetMetricGaugeSetKey Set context metric_gauge_set_key
@@ -337,8 +1801,46 @@ func (p *PluginContext) SetMetricGaugeSubKey(s string, logger log.Logger) {
 	}
 }
 
+// SetMetricGaugeMaxKey Set context metric_gauge_max_key
+// Required with Gauge Max: Yes
+func (p *PluginContext) SetMetricGaugeMaxKey(s string, logger log.Logger) {
+	if len(s) != 0 {
+		p.MetricData.Gauge.MaxKey = s
+	} else {
+		level.Error(logger).Log("msg", "metric_gauge_max_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricGaugeMinKey Set context metric_gauge_min_key
+// Required with Gauge Min: Yes
+func (p *PluginContext) SetMetricGaugeMinKey(s string, logger log.Logger) {
+	if len(s) != 0 {
+		p.MetricData.Gauge.MinKey = s
+	} else {
+		level.Error(logger).Log("msg", "metric_gauge_min_key not populated")
+		panic(1)
+	}
+}
+
+// SetMetricGaugeResetInterval Set context metric_gauge_reset_interval
+// Required: No
+// Note: Go duration string, Ex. 5m.  Only used by Max and Min, which never reset when unset.
+func (p *PluginContext) SetMetricGaugeResetInterval(s string, logger log.Logger) {
+	if len(s) != 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			level.Error(logger).Log("msg", "metric_gauge_reset_interval not a valid duration", "err", err)
+			panic(err)
+		}
+		p.MetricData.Gauge.ResetInterval = d
+	}
+}
+
 type FBCounter struct {
-	Handle *prometheus.CounterVec
+	Handle     *prometheus.CounterVec
+	Cumulative *CumulativeTracker
+	Patterns   *PatternMatcher
 }
 
 type FBGauge struct {
@@ -347,6 +1849,7 @@ type FBGauge struct {
 
 type FBHistogram struct {
 	Handle *prometheus.HistogramVec
+	Import *ImportedHistogram
 }
 
 type FBSummary struct {
@@ -358,14 +1861,38 @@ type Metric struct {
 	FBGauge
 	FBHistogram
 	FBSummary
+	FBWatermark
+	FBCorrelation
+	FBDistinct
+	FBTopK
+	FBWindow
+	FBSketch
+	FBTemplate
+	FBSLO
+	FBApdex
+	FBHeartbeat
+	FBSequence
+	FBPassthrough
+	FBStatsD
+	FBEMF
+	FBAnomaly
 }
 
 func (c *FBCounter) NewMetric(p *PluginContext) {
+	labels := p.VariableLabels
+	if p.IsPatternCounter() {
+		labels = append(append([]string{}, labels...), "pattern")
+	}
+
 	c.Handle = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name:        p.Name,
 		Help:        p.Help,
 		ConstLabels: p.ConstantLabels,
-	}, p.VariableLabels)
+	}, labels)
+
+	if p.IsCumulativeCounter() {
+		c.Cumulative = NewCumulativeTracker(p.VariableLabels)
+	}
 }
 
 func (g *FBGauge) NewMetric(p *PluginContext) {
@@ -377,6 +1904,11 @@ func (g *FBGauge) NewMetric(p *PluginContext) {
 }
 
 func (h *FBHistogram) NewMetric(p *PluginContext, Buckets []float64) {
+	if p.IsImportedHistogram() {
+		h.Import = NewImportedHistogram(p, Buckets)
+		return
+	}
+
 	h.Handle = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:        p.Name,
 		Help:        p.Help,
@@ -426,6 +1958,15 @@ func ExtractFloat(str string) (float64, error) {
 	return ret, err
 }
 
+// ParseFieldFloat Convert a record field to float64.  Unlike ExtractFloat a missing field or a
+// value that is not a number is an error, so the record can be skipped rather than observed as 0.
+func ParseFieldFloat(v interface{}) (float64, error) {
+	if v == nil {
+		return 0, fmt.Errorf("field not present")
+	}
+	return strconv.ParseFloat(strings.TrimSpace(fmt.Sprintf("%v", v)), 64)
+}
+
 // toStringSlice: Code borrowed from Loki
 // prevent base64-encoding []byte values (default json.Encoder rule) by
 // converting them to strings
@@ -511,35 +2052,144 @@ func FLBPluginInit(plugin unsafe.Pointer) int {
 	pCtx.SetPluginJobName(output.FLBPluginConfigKey(plugin, "job"))
 	pCtx.SetPushGatewayURL(output.FLBPluginConfigKey(plugin, "url"))
 	pCtx.SetPushGatewayRetries(output.FLBPluginConfigKey(plugin, "push_gateway_retries"), pCtx.Logger)
+	var alertRules []string
+	for i := 1; ; i++ {
+		v := output.FLBPluginConfigKey(plugin, fmt.Sprintf("alert_rule_%d", i))
+		if len(v) == 0 {
+			break
+		}
+		alertRules = append(alertRules, v)
+	}
+	pCtx.SetAlertRules(alertRules, pCtx.Logger)
+	if len(pCtx.AlertRules) != 0 {
+		pCtx.SetAlertmanagerURL(output.FLBPluginConfigKey(plugin, "alertmanager_url"), pCtx.Logger)
+		pCtx.SetAlertEvaluationInterval(output.FLBPluginConfigKey(plugin, "alert_evaluation_interval"), pCtx.Logger)
+	}
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("webhook_%d_", i)
+		if len(output.FLBPluginConfigKey(plugin, prefix+"url")) == 0 {
+			break
+		}
+		pCtx.AddWebhook(i, func(k string) string { return output.FLBPluginConfigKey(plugin, prefix+k) }, pCtx.Logger)
+	}
+	if len(pCtx.Webhooks) != 0 {
+		pCtx.SetWebhookEvaluationInterval(output.FLBPluginConfigKey(plugin, "webhook_evaluation_interval"), pCtx.Logger)
+	}
+	pCtx.SetEMFOutput(output.FLBPluginConfigKey(plugin, "emf_output"))
+	if len(pCtx.EMFOutput) != 0 {
+		pCtx.SetEMFNamespace(output.FLBPluginConfigKey(plugin, "emf_namespace"))
+		pCtx.SetEMFOutputInterval(output.FLBPluginConfigKey(plugin, "emf_output_interval"), pCtx.Logger)
+	}
 	pCtx.SetMetricType(output.FLBPluginConfigKey(plugin, "metric_type"))
 	pCtx.SetMetricName(output.FLBPluginConfigKey(plugin, "metric_name"))
 	pCtx.SetMetricHelp(output.FLBPluginConfigKey(plugin, "metric_help"))
 	pCtx.SetMetricConstantLabels(output.FLBPluginConfigKey(plugin, "metric_constant_labels"), pCtx.Logger)
 	pCtx.SetMetricVariableLabels(output.FLBPluginConfigKey(plugin, "metric_variable_labels"))
 
+	pCtx.RecordDecoder.SetRecordDecodeField(output.FLBPluginConfigKey(plugin, "record_decode_field"))
+	if pCtx.RecordDecoder.IsEnabled() {
+		pCtx.RecordDecoder.SetRecordDecodeFormat(output.FLBPluginConfigKey(plugin, "record_decode_format"), pCtx.Logger)
+		pCtx.RecordDecoder.SetRecordDecodePrefix(output.FLBPluginConfigKey(plugin, "record_decode_prefix"))
+	}
+
+	pCtx.RecordDedup.SetRecordDedupWindow(output.FLBPluginConfigKey(plugin, "record_dedup_window"), pCtx.Logger)
+	if pCtx.RecordDedup.IsEnabled() {
+		pCtx.RecordDedup.SetRecordDedupKey(output.FLBPluginConfigKey(plugin, "record_dedup_key"))
+		pCtx.RecordDedup.SetRecordDedupMaxEntries(output.FLBPluginConfigKey(plugin, "record_dedup_max_entries"), pCtx.Logger)
+		pCtx.RecordDedup.NewMetric(pCtx)
+	}
+
+	if pCtx.IsHistogram() {
+		pCtx.SetMetricHistogramMode(output.FLBPluginConfigKey(plugin, "metric_histogram_mode"), pCtx.Logger)
+	}
+	if pCtx.IsSummary() || pCtx.IsHistogram() {
+		pCtx.SetMetricDurationIDKey(output.FLBPluginConfigKey(plugin, "metric_duration_id_key"))
+
+		if pCtx.IsCorrelated() {
+			pCtx.SetMetricDurationEventKey(output.FLBPluginConfigKey(plugin, "metric_duration_event_key"), pCtx.Logger)
+			pCtx.SetMetricDurationStartValue(output.FLBPluginConfigKey(plugin, "metric_duration_start_value"), pCtx.Logger)
+			pCtx.SetMetricDurationEndValue(output.FLBPluginConfigKey(plugin, "metric_duration_end_value"), pCtx.Logger)
+			pCtx.SetMetricDurationTTL(output.FLBPluginConfigKey(plugin, "metric_duration_ttl"), pCtx.Logger)
+			pCtx.SetMetricDurationMaxPending(output.FLBPluginConfigKey(plugin, "metric_duration_max_pending"), pCtx.Logger)
+			pCtx.FBCorrelation.NewMetric(pCtx)
+		}
+	}
 	if pCtx.IsSummary() {
-		pCtx.SetMetricSummaryObserveKey(output.FLBPluginConfigKey(plugin, "metric_summary_observe_key"), pCtx.Logger)
+		if !pCtx.IsCorrelated() {
+			pCtx.SetMetricSummaryObserveKey(output.FLBPluginConfigKey(plugin, "metric_summary_observe_key"), pCtx.Logger)
+		}
 		pCtx.FBSummary.NewMetric(pCtx)
 	}
 	if pCtx.IsGauge() {
-		pCtx.SetMetricGaugeMethod(output.FLBPluginConfigKey(plugin, "metric_gauge_method"), pCtx.Logger)
-
-		switch pCtx.MetricData.Gauge.Method {
-		case "Set":
-			pCtx.SetMetricGaugeSetKey(output.FLBPluginConfigKey(plugin, "metric_gauge_set_key"), pCtx.Logger)
-		case "Add":
-			pCtx.SetMetricGaugeAddKey(output.FLBPluginConfigKey(plugin, "metric_gauge_add_key"), pCtx.Logger)
-		case "Sub":
-			pCtx.SetMetricGaugeSubKey(output.FLBPluginConfigKey(plugin, "metric_gauge_sub_key"), pCtx.Logger)
-		case "Inc":
-		case "Dec":
-		default:
-			level.Error(pCtx.Logger).Log("Unknown metric_gauge_method ", pCtx.MetricData.Gauge.Method)
+		pCtx.SetMetricGaugeMethodKey(output.FLBPluginConfigKey(plugin, "metric_gauge_method_key"))
+
+		var methods []string
+		if len(pCtx.MetricData.Gauge.MethodKey) != 0 {
+			pCtx.SetMetricGaugeMethodMap(output.FLBPluginConfigKey(plugin, "metric_gauge_method_map"), pCtx.Logger)
+			// metric_gauge_method is optional here and only applies to values missing from the map
+			pCtx.MetricData.Gauge.Method = output.FLBPluginConfigKey(plugin, "metric_gauge_method")
+			for _, m := range pCtx.MetricData.Gauge.MethodMap {
+				methods = append(methods, m)
+			}
+			if pCtx.IsWatermarkGauge() {
+				level.Error(pCtx.Logger).Log("msg", "metric_gauge_method Max and Min cannot be combined with metric_gauge_method_key")
+				panic(1)
+			}
+		} else {
+			pCtx.SetMetricGaugeMethod(output.FLBPluginConfigKey(plugin, "metric_gauge_method"), pCtx.Logger)
+		}
+		if len(pCtx.MetricData.Gauge.Method) != 0 {
+			methods = append(methods, pCtx.MetricData.Gauge.Method)
+		}
+
+		for _, method := range methods {
+			switch method {
+			case "Set":
+				pCtx.SetMetricGaugeSetKey(output.FLBPluginConfigKey(plugin, "metric_gauge_set_key"), pCtx.Logger)
+			case "Add":
+				pCtx.SetMetricGaugeAddKey(output.FLBPluginConfigKey(plugin, "metric_gauge_add_key"), pCtx.Logger)
+			case "Sub":
+				pCtx.SetMetricGaugeSubKey(output.FLBPluginConfigKey(plugin, "metric_gauge_sub_key"), pCtx.Logger)
+			case "Max":
+				pCtx.SetMetricGaugeMaxKey(output.FLBPluginConfigKey(plugin, "metric_gauge_max_key"), pCtx.Logger)
+				pCtx.SetMetricGaugeResetInterval(output.FLBPluginConfigKey(plugin, "metric_gauge_reset_interval"), pCtx.Logger)
+			case "Min":
+				pCtx.SetMetricGaugeMinKey(output.FLBPluginConfigKey(plugin, "metric_gauge_min_key"), pCtx.Logger)
+				pCtx.SetMetricGaugeResetInterval(output.FLBPluginConfigKey(plugin, "metric_gauge_reset_interval"), pCtx.Logger)
+			case "Inc":
+			case "Dec":
+			case "SetToRecordTime":
+			case "SetToCurrentTime":
+			default:
+				if _, ok := GaugeSetConstant(method); !ok {
+					level.Error(pCtx.Logger).Log("Unknown metric_gauge_method ", method)
+				}
+			}
+		}
+		if pCtx.IsWatermarkGauge() {
+			pCtx.FBWatermark.NewMetric(pCtx)
+		} else {
+			pCtx.FBGauge.NewMetric(pCtx)
 		}
-		pCtx.FBGauge.NewMetric(pCtx)
 	}
-	if pCtx.IsHistogram() {
-		pCtx.SetMetricHistogramBucketType(output.FLBPluginConfigKey(plugin, "metric_histogram_bucket_type"), pCtx.Logger)
+	if pCtx.IsHistogram() || pCtx.IsLag() {
+		if pCtx.IsImportedHistogram() {
+			pCtx.SetMetricHistogramImportBucketsKey(output.FLBPluginConfigKey(plugin, "metric_histogram_import_buckets_key"))
+			pCtx.SetMetricHistogramImportBucketPrefix(output.FLBPluginConfigKey(plugin, "metric_histogram_import_bucket_prefix"))
+			pCtx.SetMetricHistogramImportBucketCounts(output.FLBPluginConfigKey(plugin, "metric_histogram_import_bucket_counts"), pCtx.Logger)
+			pCtx.SetMetricHistogramImportSumKey(output.FLBPluginConfigKey(plugin, "metric_histogram_import_sum_key"))
+			pCtx.SetMetricHistogramImportCountKey(output.FLBPluginConfigKey(plugin, "metric_histogram_import_count_key"))
+		}
+
+		if pCtx.IsImportedHistogram() && len(output.FLBPluginConfigKey(plugin, "metric_histogram_bucket_type")) == 0 {
+			// Bounds are read from the records themselves
+			pCtx.FBHistogram.NewMetric(pCtx, nil)
+		} else if pCtx.IsLag() && len(output.FLBPluginConfigKey(plugin, "metric_histogram_bucket_type")) == 0 {
+			// Lag falls back to the client library default buckets, which suit delays in seconds
+			pCtx.FBHistogram.NewMetric(pCtx, prometheus.DefBuckets)
+		} else {
+			pCtx.SetMetricHistogramBucketType(output.FLBPluginConfigKey(plugin, "metric_histogram_bucket_type"), pCtx.Logger)
+		}
 
 		if pCtx.IsLinearBucket() {
 			pCtx.SetMetricHistogramLinearBucketsCount(output.FLBPluginConfigKey(plugin, "metric_histogram_linear_buckets_count"), pCtx.Logger)
@@ -573,13 +2223,151 @@ func FLBPluginInit(plugin unsafe.Pointer) int {
 				level.Error(pCtx.Logger).Log("msg", "Histogram Exponential Buckets failed type conversion", err)
 			}
 		}
-		pCtx.SetMetricHistogramObserveKey(output.FLBPluginConfigKey(plugin, "metric_histogram_observe_key"), pCtx.Logger)
+		if pCtx.IsHistogram() && !pCtx.IsCorrelated() && !pCtx.IsImportedHistogram() {
+			pCtx.SetMetricHistogramObserveKey(output.FLBPluginConfigKey(plugin, "metric_histogram_observe_key"), pCtx.Logger)
+		}
+		if pCtx.IsLag() {
+			pCtx.SetMetricLagTimeKey(output.FLBPluginConfigKey(plugin, "metric_lag_time_key"))
+			pCtx.SetMetricLagTimeLayout(output.FLBPluginConfigKey(plugin, "metric_lag_time_layout"))
+		}
+	}
+	if pCtx.IsDistinct() {
+		pCtx.SetMetricDistinctKey(output.FLBPluginConfigKey(plugin, "metric_distinct_key"), pCtx.Logger)
+		pCtx.SetMetricDistinctWindow(output.FLBPluginConfigKey(plugin, "metric_distinct_window"), pCtx.Logger)
+		pCtx.SetMetricDistinctPrecision(output.FLBPluginConfigKey(plugin, "metric_distinct_precision"), pCtx.Logger)
+		pCtx.FBDistinct.NewMetric(pCtx)
+	}
+	if pCtx.IsTopK() {
+		pCtx.SetMetricTopKKey(output.FLBPluginConfigKey(plugin, "metric_topk_key"), pCtx.Logger)
+		pCtx.SetMetricTopKK(output.FLBPluginConfigKey(plugin, "metric_topk_k"), pCtx.Logger)
+		pCtx.SetMetricTopKCapacity(output.FLBPluginConfigKey(plugin, "metric_topk_capacity"), pCtx.Logger)
+		pCtx.SetMetricTopKWindow(output.FLBPluginConfigKey(plugin, "metric_topk_window"), pCtx.Logger)
+		pCtx.SetMetricTopKLabel(output.FLBPluginConfigKey(plugin, "metric_topk_label"), pCtx.Logger)
+		pCtx.FBTopK.NewMetric(pCtx)
+	}
+	if pCtx.IsWindow() {
+		pCtx.SetMetricWindowObserveKey(output.FLBPluginConfigKey(plugin, "metric_window_observe_key"), pCtx.Logger)
+		pCtx.SetMetricWindowMode(output.FLBPluginConfigKey(plugin, "metric_window_mode"), pCtx.Logger)
+		pCtx.SetMetricWindowLength(output.FLBPluginConfigKey(plugin, "metric_window_length"), pCtx.Logger)
+		pCtx.SetMetricWindowStep(output.FLBPluginConfigKey(plugin, "metric_window_step"), pCtx.Logger)
+		pCtx.FBWindow.NewMetric(pCtx)
+	}
+	if pCtx.IsSketch() {
+		pCtx.SetMetricSketchObserveKey(output.FLBPluginConfigKey(plugin, "metric_sketch_observe_key"), pCtx.Logger)
+		pCtx.SetMetricSketchRelativeAccuracy(output.FLBPluginConfigKey(plugin, "metric_sketch_relative_accuracy"), pCtx.Logger)
+		pCtx.SetMetricSketchQuantiles(output.FLBPluginConfigKey(plugin, "metric_sketch_quantiles"), pCtx.Logger)
+		pCtx.SetMetricSketchExportURL(output.FLBPluginConfigKey(plugin, "metric_sketch_export_url"))
+		pCtx.SetMetricSketchExportInterval(output.FLBPluginConfigKey(plugin, "metric_sketch_export_interval"), pCtx.Logger)
+		pCtx.FBSketch.NewMetric(pCtx)
+	}
+	if pCtx.IsTemplate() {
+		pCtx.SetMetricTemplateKey(output.FLBPluginConfigKey(plugin, "metric_template_key"), pCtx.Logger)
+		pCtx.SetMetricTemplateMax(output.FLBPluginConfigKey(plugin, "metric_template_max"), pCtx.Logger)
+		pCtx.SetMetricTemplateSimilarity(output.FLBPluginConfigKey(plugin, "metric_template_similarity"), pCtx.Logger)
+		pCtx.SetMetricTemplateDepth(output.FLBPluginConfigKey(plugin, "metric_template_depth"), pCtx.Logger)
+		pCtx.SetMetricTemplateLabel(output.FLBPluginConfigKey(plugin, "metric_template_label"), pCtx.Logger)
+		pCtx.FBTemplate.NewMetric(pCtx)
+	}
+	if pCtx.IsSLO() {
+		pCtx.SetMetricSLOBadCondition(output.FLBPluginConfigKey(plugin, "metric_slo_bad_condition"), pCtx.Logger)
+		pCtx.SetMetricSLOIgnoreCondition(output.FLBPluginConfigKey(plugin, "metric_slo_ignore_condition"), pCtx.Logger)
+		pCtx.SetMetricSLOObjective(output.FLBPluginConfigKey(plugin, "metric_slo_objective"), pCtx.Logger)
+		pCtx.SetMetricSLOWindows(output.FLBPluginConfigKey(plugin, "metric_slo_windows"), pCtx.Logger)
+		pCtx.FBSLO.NewMetric(pCtx)
+	}
+	if pCtx.IsApdex() {
+		pCtx.SetMetricApdexObserveKey(output.FLBPluginConfigKey(plugin, "metric_apdex_observe_key"), pCtx.Logger)
+		pCtx.SetMetricApdexThreshold(output.FLBPluginConfigKey(plugin, "metric_apdex_threshold"), pCtx.Logger)
+		pCtx.SetMetricApdexWindow(output.FLBPluginConfigKey(plugin, "metric_apdex_window"), pCtx.Logger)
+		pCtx.FBApdex.NewMetric(pCtx)
+	}
+	if pCtx.IsHeartbeat() {
+		pCtx.SetMetricHeartbeatInterval(output.FLBPluginConfigKey(plugin, "metric_heartbeat_interval"), pCtx.Logger)
+		pCtx.SetMetricHeartbeatExpected(output.FLBPluginConfigKey(plugin, "metric_heartbeat_expected"), pCtx.Logger)
+		pCtx.SetMetricHeartbeatLearn(output.FLBPluginConfigKey(plugin, "metric_heartbeat_learn"), pCtx.Logger)
+		pCtx.SetMetricHeartbeatForgetAfter(output.FLBPluginConfigKey(plugin, "metric_heartbeat_forget_after"), pCtx.Logger)
+		pCtx.FBHeartbeat.NewMetric(pCtx)
+	}
+	if pCtx.IsSequence() {
+		pCtx.SetMetricSequenceKey(output.FLBPluginConfigKey(plugin, "metric_sequence_key"), pCtx.Logger)
+		pCtx.SetMetricSequenceStreamKey(output.FLBPluginConfigKey(plugin, "metric_sequence_stream_key"))
+		pCtx.SetMetricSequenceWindow(output.FLBPluginConfigKey(plugin, "metric_sequence_window"), pCtx.Logger)
+		pCtx.SetMetricSequenceMaxStreams(output.FLBPluginConfigKey(plugin, "metric_sequence_max_streams"), pCtx.Logger)
+		pCtx.FBSequence.NewMetric(pCtx)
+	}
+	if pCtx.IsPassthrough() {
+		pCtx.SetMetricPassthroughKey(output.FLBPluginConfigKey(plugin, "metric_passthrough_key"), pCtx.Logger)
+		pCtx.SetMetricPassthroughTagLabels(output.FLBPluginConfigKey(plugin, "metric_passthrough_tag_labels"), pCtx.Logger)
+		pCtx.SetMetricPassthroughTTL(output.FLBPluginConfigKey(plugin, "metric_passthrough_ttl"), pCtx.Logger)
+		pCtx.SetMetricPassthroughMaxSeries(output.FLBPluginConfigKey(plugin, "metric_passthrough_max_series"), pCtx.Logger)
+		pCtx.FBPassthrough.NewMetric(pCtx)
+	}
+	if pCtx.IsStatsD() {
+		pCtx.SetMetricStatsDKey(output.FLBPluginConfigKey(plugin, "metric_statsd_key"), pCtx.Logger)
+		var mappings []string
+		for i := 1; ; i++ {
+			v := output.FLBPluginConfigKey(plugin, fmt.Sprintf("metric_statsd_mapping_%d", i))
+			if len(v) == 0 {
+				break
+			}
+			mappings = append(mappings, v)
+		}
+		pCtx.SetMetricStatsDMappings(mappings, pCtx.Logger)
+		pCtx.SetMetricStatsDDropUnmapped(output.FLBPluginConfigKey(plugin, "metric_statsd_drop_unmapped"), pCtx.Logger)
+		pCtx.SetMetricStatsDBuckets(output.FLBPluginConfigKey(plugin, "metric_statsd_buckets"), pCtx.Logger)
+		pCtx.SetMetricStatsDMaxSeries(output.FLBPluginConfigKey(plugin, "metric_statsd_max_series"), pCtx.Logger)
+		pCtx.FBStatsD.NewMetric(pCtx)
+	}
+	if pCtx.IsEMF() {
+		pCtx.SetMetricEMFKey(output.FLBPluginConfigKey(plugin, "metric_emf_key"))
+		pCtx.SetMetricEMFBuckets(output.FLBPluginConfigKey(plugin, "metric_emf_buckets"), pCtx.Logger)
+		pCtx.SetMetricEMFMaxSeries(output.FLBPluginConfigKey(plugin, "metric_emf_max_series"), pCtx.Logger)
+		pCtx.SetMetricEMFMaxSeriesPerMetric(output.FLBPluginConfigKey(plugin, "metric_emf_max_series_per_metric"), pCtx.Logger)
+		pCtx.FBEMF.NewMetric(pCtx)
+	}
+	pCtx.SetMetricAnomalyInterval(output.FLBPluginConfigKey(plugin, "metric_anomaly_interval"), pCtx.Logger)
+	if pCtx.HasAnomalyDetection() {
+		pCtx.SetMetricAnomalyObserveKey(output.FLBPluginConfigKey(plugin, "metric_anomaly_observe_key"))
+		pCtx.SetMetricAnomalyAlpha(output.FLBPluginConfigKey(plugin, "metric_anomaly_alpha"), pCtx.Logger)
+		pCtx.SetMetricAnomalyThreshold(output.FLBPluginConfigKey(plugin, "metric_anomaly_threshold"), pCtx.Logger)
+		pCtx.SetMetricAnomalyEvents(output.FLBPluginConfigKey(plugin, "metric_anomaly_events"), pCtx.Logger)
+		pCtx.FBAnomaly.NewMetric(pCtx)
 	}
 	if pCtx.IsCounter() {
+		pCtx.SetMetricCounterMode(output.FLBPluginConfigKey(plugin, "metric_counter_mode"), pCtx.Logger)
+
+		if pCtx.IsCumulativeCounter() {
+			pCtx.SetMetricCounterSourceKey(output.FLBPluginConfigKey(plugin, "metric_counter_source_key"), pCtx.Logger)
+
+			// Source counters are exposed as proper Prometheus counters
+			if !strings.HasSuffix(pCtx.Name, "_total") {
+				pCtx.Name += "_total"
+			}
+		}
+		if pCtx.IsPatternCounter() {
+			pCtx.SetMetricCounterPatternKey(output.FLBPluginConfigKey(plugin, "metric_counter_pattern_key"), pCtx.Logger)
+
+			var patterns []string
+			for i := 1; ; i++ {
+				v := output.FLBPluginConfigKey(plugin, fmt.Sprintf("metric_counter_pattern_%d", i))
+				if len(v) == 0 {
+					break
+				}
+				patterns = append(patterns, ConfigKeyQuoteTrim(v))
+			}
+			pCtx.SetMetricCounterPatterns(patterns, pCtx.Logger)
+
+			var err error
+			pCtx.FBCounter.Patterns, err = NewPatternMatcher(pCtx.MetricData.Counter.Patterns)
+			if err != nil {
+				level.Error(pCtx.Logger).Log("msg", "Invalid metric_counter_pattern", "err", err)
+				panic(err)
+			}
+		}
 		pCtx.FBCounter.NewMetric(pCtx)
 	}
 
-	if pCtx.IsGauge() {
+	if pCtx.IsGauge() && !pCtx.IsWatermarkGauge() {
 		pCtx.FBGauge.NewMetric(pCtx)
 	}
 
@@ -611,16 +2399,84 @@ func FLBPluginInit(plugin unsafe.Pointer) int {
 		registry.MustRegister(pCtx.FBSummary.Handle)
 	}
 
-	if pCtx.IsHistogram() {
+	if pCtx.IsImportedHistogram() {
+		registry.MustRegister(pCtx.FBHistogram.Import)
+	} else if pCtx.IsHistogram() || pCtx.IsLag() {
 		registry.MustRegister(pCtx.FBHistogram.Handle)
 	}
 
-	if pCtx.IsGauge() {
+	if pCtx.IsCorrelated() {
+		registry.MustRegister(pCtx.FBCorrelation.Handle)
+	}
+
+	if pCtx.IsDistinct() {
+		registry.MustRegister(pCtx.FBDistinct.Handle)
+	}
+
+	if pCtx.IsTopK() {
+		registry.MustRegister(pCtx.FBTopK.Handle)
+	}
+
+	if pCtx.IsWindow() {
+		registry.MustRegister(pCtx.FBWindow.Handle)
+	}
+
+	if pCtx.IsSketch() {
+		registry.MustRegister(pCtx.FBSketch.Handle)
+	}
+
+	if pCtx.IsTemplate() {
+		registry.MustRegister(pCtx.FBTemplate.Handle)
+		registry.MustRegister(pCtx.FBTemplate.Info)
+	}
+
+	if pCtx.IsSLO() {
+		registry.MustRegister(pCtx.FBSLO.Handle)
+	}
+
+	if pCtx.IsApdex() {
+		registry.MustRegister(pCtx.FBApdex.Handle)
+	}
+
+	if pCtx.IsHeartbeat() {
+		registry.MustRegister(pCtx.FBHeartbeat.Handle)
+	}
+
+	if pCtx.IsSequence() {
+		registry.MustRegister(pCtx.FBSequence.Handle)
+	}
+
+	if pCtx.IsStatsD() {
+		registry.MustRegister(pCtx.FBStatsD.Handle, pCtx.FBStatsD.Handle.ParseErrors, pCtx.FBStatsD.Handle.Dropped)
+	}
+
+	if pCtx.IsEMF() {
+		registry.MustRegister(pCtx.FBEMF.Handle, pCtx.FBEMF.Handle.Errors, pCtx.FBEMF.Handle.Dropped)
+	}
+
+	// Relayed families are gathered alongside the registry rather than registered in it
+	var gatherer prometheus.Gatherer = registry
+	if pCtx.IsPassthrough() {
+		registry.MustRegister(pCtx.FBPassthrough.Handle.ParseErrors, pCtx.FBPassthrough.Handle.Dropped)
+		gatherer = prometheus.Gatherers{registry, pCtx.FBPassthrough.Handle}
+	}
+
+	if pCtx.HasAnomalyDetection() {
+		registry.MustRegister(pCtx.FBAnomaly.Handle)
+	}
+
+	if pCtx.RecordDedup.IsEnabled() {
+		registry.MustRegister(pCtx.RecordDedup.Hits, pCtx.RecordDedup.Misses)
+	}
+
+	if pCtx.IsWatermarkGauge() {
+		registry.MustRegister(pCtx.FBWatermark.Handle)
+	} else if pCtx.IsGauge() {
 		registry.MustRegister(pCtx.FBGauge.Handle)
 	}
 
 	// Initialize new metric with push gateway
-	pCtx.Pusher = push.New(pCtx.URL, pCtx.Job).Gatherer(registry)
+	pCtx.Pusher = push.New(pCtx.URL, pCtx.Job).Gatherer(gatherer)
 
 	if err := pCtx.Pusher.Add(); err == nil {
 		// Reset retry counter to zero and return error
@@ -641,6 +2497,27 @@ func FLBPluginInit(plugin unsafe.Pointer) int {
 		return ret
 	}
 
+	if pCtx.IsHeartbeat() {
+		go pCtx.FBHeartbeat.Handle.run(pCtx.Pusher, pCtx.Logger)
+	}
+	if len(pCtx.AlertRules) != 0 {
+		pCtx.Alerter = NewAlerter(pCtx, gatherer)
+		go pCtx.Alerter.Run()
+	}
+	if len(pCtx.Webhooks) != 0 {
+		pCtx.Notifier = NewNotifier(pCtx, gatherer)
+		go pCtx.Notifier.Run()
+	}
+	if len(pCtx.EMFOutput) != 0 {
+		w, err := NewEMFWriter(pCtx, gatherer)
+		if err != nil {
+			level.Error(pCtx.Logger).Log("msg", "Could not open emf_output", "output", pCtx.EMFOutput, "err", err)
+			return output.FLB_ERROR
+		}
+		pCtx.EMFWriter = w
+		go pCtx.EMFWriter.Run()
+	}
+
 	// Set the context to point to any Go variable
 	output.FLBPluginSetContext(plugin, pCtx)
 
@@ -682,6 +2559,12 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 		// Print record keys and values
 		msgPrefix := fmt.Sprintf("[%d] %v: [%s] {", count, C.GoString(tag), timestamp.String())
 		records := toStringMap(record)
+		fields := NewRecordFields(records, &pCtx.RecordDecoder, pCtx.Logger)
+
+		if pCtx.RecordDedup.IsEnabled() && pCtx.RecordDedup.Seen(records, fields, timestamp, pCtx.Logger) {
+			level.Debug(pCtx.Logger).Log("msg", "Skipping duplicate record", "tag", C.GoString(tag))
+			continue
+		}
 
 		var msgRecords string
 		if pCtx.LogLevel == "debug" {
@@ -695,24 +2578,49 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 		var msgKeys string
 		for _, lk := range pCtx.VariableLabels {
 			if pCtx.LogLevel == "debug" {
-				msgKeys += fmt.Sprintf("|%s=%s|", lk, records[lk])
+				msgKeys += fmt.Sprintf("|%s=%s|", lk, fields.Get(lk))
 			}
 
 			// This takes the value of a fluent bit key and assigns it to a GoLang map
-			metricLabels[lk] = fmt.Sprintf("%v", records[lk])
+			metricLabels[lk] = fmt.Sprintf("%v", fields.Get(lk))
 		}
 
 		level.Debug(pCtx.Logger).Log("msg", msgPrefix+msgRecords+msgKeys+"}")
 		count++
-		if pCtx.IsCounter() {
+		if pCtx.IsCumulativeCounter() {
+			s := fields.Get(pCtx.MetricData.Counter.SourceKey)
+			v, err := ParseFieldFloat(s)
+			if err == nil && (v < 0 || math.IsNaN(v)) {
+				err = fmt.Errorf("source counter is not a non-negative number")
+			}
+
+			// Missing or unparseable values never reach Delta, where they would look like a source restart
+			if err == nil {
+				pCtx.FBCounter.Handle.With(metricLabels).Add(pCtx.FBCounter.Cumulative.Delta(metricLabels, v))
+			} else {
+				level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+			}
+		} else if pCtx.IsPatternCounter() {
+			if v := fields.Get(pCtx.MetricData.Counter.PatternKey); v != nil {
+				for _, name := range pCtx.FBCounter.Patterns.Match(fmt.Sprintf("%v", v)) {
+					l := prometheus.Labels{"pattern": name}
+					for k, lv := range metricLabels {
+						l[k] = lv
+					}
+					pCtx.FBCounter.Handle.With(l).Inc()
+				}
+			}
+		} else if pCtx.IsCounter() {
 			pCtx.FBCounter.Handle.With(metricLabels).Inc()
 			level.Debug(pCtx.Logger).Log("metric_description", pCtx.FBCounter.Handle.With(metricLabels).Desc().String())
 		}
 
 		if pCtx.IsGauge() {
-			switch pCtx.MetricData.Gauge.Method {
+			method := pCtx.GaugeMethod(fields)
+
+			switch method {
 			case "Set":
-				s := fmt.Sprintf("%v", records[pCtx.MetricData.Gauge.SetKey])
+				s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Gauge.SetKey))
 				v, err := ExtractFloat(s)
 
 				if err == nil {
@@ -722,7 +2630,7 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 				}
 
 			case "Add":
-				s := fmt.Sprintf("%v", records[pCtx.MetricData.Gauge.AddKey])
+				s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Gauge.AddKey))
 				v, err := ExtractFloat(s)
 
 				if err == nil {
@@ -733,7 +2641,7 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 				}
 
 			case "Sub":
-				s := fmt.Sprintf("%v", records[pCtx.MetricData.Gauge.SubKey])
+				s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Gauge.SubKey))
 				v, err := ExtractFloat(s)
 
 				if err == nil {
@@ -742,16 +2650,62 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 					level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
 				}
 
+			case "Max":
+				s := fields.Get(pCtx.MetricData.Gauge.MaxKey)
+				v, err := ParseFieldFloat(s)
+
+				if err == nil {
+					pCtx.FBWatermark.Handle.Observe(metricLabels, v)
+				} else {
+					level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+				}
+
+			case "Min":
+				s := fields.Get(pCtx.MetricData.Gauge.MinKey)
+				v, err := ParseFieldFloat(s)
+
+				if err == nil {
+					pCtx.FBWatermark.Handle.Observe(metricLabels, v)
+				} else {
+					level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+				}
+
 			case "Inc":
 				pCtx.FBGauge.Handle.With(metricLabels).Inc()
 			case "Dec":
 				pCtx.FBGauge.Handle.With(metricLabels).Dec()
+			case "SetToRecordTime":
+				pCtx.FBGauge.Handle.With(metricLabels).Set(float64(timestamp.UnixNano()) / 1e9)
+			case "SetToCurrentTime":
+				pCtx.FBGauge.Handle.With(metricLabels).SetToCurrentTime()
+			case "":
+				level.Debug(pCtx.Logger).Log("msg", "No gauge method mapped for record", "metric_gauge_method_key", pCtx.MetricData.Gauge.MethodKey)
 			default:
-				level.Error(pCtx.Logger).Log("Unknown metric_gauge_method ", pCtx.MetricData.Gauge.Method)
+				if v, ok := GaugeSetConstant(method); ok {
+					pCtx.FBGauge.Handle.With(metricLabels).Set(v)
+				} else {
+					level.Error(pCtx.Logger).Log("Unknown metric_gauge_method ", method)
+				}
+			}
+		}
+		if pCtx.IsCorrelated() {
+			event := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Correlation.EventKey))
+			rawID := fields.Get(pCtx.MetricData.Correlation.IDKey)
+
+			// Records without an id would all correlate with each other
+			if rawID == nil {
+				level.Debug(pCtx.Logger).Log("msg", "Skipping record without correlation id", "key", pCtx.MetricData.Correlation.IDKey)
+			} else if elapsed, ok := pCtx.FBCorrelation.Handle.Track(event, fmt.Sprintf("%v", rawID), timestamp, metricLabels); ok {
+				if pCtx.IsSummary() {
+					pCtx.FBSummary.Handle.With(metricLabels).Observe(elapsed.Seconds())
+				}
+				if pCtx.IsHistogram() {
+					pCtx.FBHistogram.Handle.With(metricLabels).Observe(elapsed.Seconds())
+				}
 			}
 		}
-		if pCtx.IsSummary() {
-			s := fmt.Sprintf("%v", records[pCtx.MetricData.Summary.ObserveKey])
+		if pCtx.IsSummary() && !pCtx.IsCorrelated() {
+			s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Summary.ObserveKey))
 			v, err := ExtractFloat(s)
 
 			if err == nil {
@@ -760,8 +2714,177 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 				level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
 			}
 		}
-		if pCtx.IsHistogram() {
-			s := fmt.Sprintf("%v", records[pCtx.MetricData.Histogram.ObserveKey])
+		if pCtx.IsDistinct() {
+			if v := fields.Get(pCtx.MetricData.Distinct.Key); v != nil {
+				pCtx.FBDistinct.Handle.Observe(metricLabels, fmt.Sprintf("%v", v))
+			}
+		}
+		if pCtx.IsTopK() {
+			if v := fields.Get(pCtx.MetricData.TopK.Key); v != nil {
+				pCtx.FBTopK.Handle.Observe(metricLabels, fmt.Sprintf("%v", v))
+			}
+		}
+		if pCtx.IsWindow() {
+			s := fields.Get(pCtx.MetricData.Window.ObserveKey)
+			v, err := ParseFieldFloat(s)
+
+			if err == nil {
+				pCtx.FBWindow.Handle.Observe(metricLabels, v)
+			} else {
+				level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+			}
+		}
+		if pCtx.IsSketch() {
+			// A missing value must not be recorded as 0, nor NaN or Inf land in the sketch
+			s := fields.Get(pCtx.MetricData.Sketch.ObserveKey)
+			v, err := ParseFieldFloat(s)
+			if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+				err = fmt.Errorf("value %v is not finite", v)
+			}
+
+			if err == nil {
+				pCtx.FBSketch.Handle.Observe(metricLabels, v)
+			} else {
+				level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+			}
+		}
+		if pCtx.IsTemplate() {
+			if v := fields.Get(pCtx.MetricData.Template.Key); v != nil {
+				pCtx.FBTemplate.Observe(metricLabels, fmt.Sprintf("%v", v))
+			}
+		}
+		if pCtx.IsSLO() {
+			pCtx.FBSLO.Handle.Observe(metricLabels, fields.Get)
+		}
+		if pCtx.IsApdex() {
+			// A missing latency must not count as satisfied
+			s := fields.Get(pCtx.MetricData.Apdex.ObserveKey)
+			v, err := ParseFieldFloat(s)
+			if err == nil && math.IsNaN(v) {
+				err = fmt.Errorf("latency is NaN")
+			}
+
+			if err == nil {
+				pCtx.FBApdex.Handle.Observe(metricLabels, v)
+			} else {
+				level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+			}
+		}
+		if pCtx.IsHeartbeat() {
+			pCtx.FBHeartbeat.Handle.Beat(metricLabels, time.Now())
+		}
+		if pCtx.IsPassthrough() {
+			if v := fields.Get(pCtx.MetricData.Passthrough.Key); v != nil {
+				if err := pCtx.FBPassthrough.Handle.Ingest(fmt.Sprintf("%v", v), C.GoString(tag), metricLabels); err != nil {
+					level.Error(pCtx.Logger).Log("msg", "Unable to parse exposition text", "key", pCtx.MetricData.Passthrough.Key, "err", err)
+				}
+			}
+		}
+		if pCtx.IsEMF() {
+			emf, err := records, error(nil)
+			if len(pCtx.MetricData.EMF.Key) != 0 {
+				emf = nil
+				if v := fields.Get(pCtx.MetricData.EMF.Key); v != nil {
+					emf, err = ParseEMFRecord(fmt.Sprintf("%v", v))
+				}
+			}
+
+			if err == nil && emf != nil {
+				var ok bool
+				if ok, err = pCtx.FBEMF.Handle.Ingest(emf, metricLabels); !ok {
+					level.Debug(pCtx.Logger).Log("msg", "Record is not EMF")
+				}
+			}
+			if err != nil {
+				level.Error(pCtx.Logger).Log("msg", "Unable to ingest EMF record", "err", err)
+			}
+		}
+		if pCtx.IsStatsD() {
+			if v := fields.Get(pCtx.MetricData.StatsD.Key); v != nil {
+				for _, line := range strings.Split(fmt.Sprintf("%v", v), "\n") {
+					if line = strings.TrimSpace(line); len(line) == 0 {
+						continue
+					}
+					if err := pCtx.FBStatsD.Handle.Ingest(line, metricLabels); err != nil {
+						level.Error(pCtx.Logger).Log("msg", "Unable to ingest statsd line", "err", err)
+					}
+				}
+			}
+		}
+		if pCtx.IsSequence() {
+			seq, err := ParseSequence(fields.Get(pCtx.MetricData.Sequence.Key))
+
+			if err == nil {
+				var stream string
+				if len(pCtx.MetricData.Sequence.StreamKey) != 0 {
+					stream = fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Sequence.StreamKey))
+				}
+				pCtx.FBSequence.Handle.Observe(metricLabels, stream, seq)
+			} else {
+				level.Error(pCtx.Logger).Log("msg", "Unable to read sequence number", "key", pCtx.MetricData.Sequence.Key, "err", err)
+			}
+		}
+		if pCtx.HasAnomalyDetection() {
+			if len(pCtx.MetricData.Anomaly.ObserveKey) == 0 {
+				pCtx.FBAnomaly.Handle.Observe(metricLabels, 1)
+			} else {
+				// A missing value must not count as 0, nor NaN or Inf poison the moving averages
+				s := fields.Get(pCtx.MetricData.Anomaly.ObserveKey)
+				v, err := ParseFieldFloat(s)
+				if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+					err = fmt.Errorf("value %v is not finite", v)
+				}
+
+				if err == nil {
+					pCtx.FBAnomaly.Handle.Observe(metricLabels, v)
+				} else {
+					level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+				}
+			}
+		}
+		if pCtx.IsLag() {
+			eventTime := timestamp
+
+			if len(pCtx.MetricData.Lag.TimeKey) != 0 {
+				var err error
+				eventTime, err = ParseEventTime(fields.Get(pCtx.MetricData.Lag.TimeKey), pCtx.MetricData.Lag.TimeLayout)
+				if err != nil {
+					level.Error(pCtx.Logger).Log("msg", "Unable to parse metric_lag_time_key", "layout", pCtx.MetricData.Lag.TimeLayout, "err", err)
+				}
+			}
+			if !eventTime.IsZero() {
+				pCtx.FBHistogram.Handle.With(metricLabels).Observe(time.Since(eventTime).Seconds())
+			}
+		}
+		if pCtx.IsImportedHistogram() {
+			var bucketField interface{}
+			if len(pCtx.MetricData.Histogram.ImportBucketsKey) != 0 {
+				bucketField = fields.Get(pCtx.MetricData.Histogram.ImportBucketsKey)
+			}
+			buckets, err := pCtx.FBHistogram.Import.ParseBuckets(bucketField, records)
+
+			if err == nil {
+				count, sum := -1.0, 0.0
+				if len(pCtx.MetricData.Histogram.ImportCountKey) != 0 {
+					s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Histogram.ImportCountKey))
+					if count, err = strconv.ParseFloat(s, 64); err != nil {
+						level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+						count = -1
+					}
+				}
+				if len(pCtx.MetricData.Histogram.ImportSumKey) != 0 {
+					s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Histogram.ImportSumKey))
+					if sum, err = strconv.ParseFloat(s, 64); err != nil {
+						level.Error(pCtx.Logger).Log("Unable to convert %s into a float64", s, "err", err)
+						sum = 0
+					}
+				}
+				pCtx.FBHistogram.Import.Merge(metricLabels, buckets, count, sum)
+			} else {
+				level.Error(pCtx.Logger).Log("msg", "Unable to import histogram buckets", "err", err)
+			}
+		} else if pCtx.IsHistogram() && !pCtx.IsCorrelated() {
+			s := fmt.Sprintf("%v", fields.Get(pCtx.MetricData.Histogram.ObserveKey))
 			v, err := ExtractFloat(s)
 
 			if err == nil {
@@ -799,5 +2922,35 @@ func FLBPluginExit() int {
 	return output.FLB_OK
 }
 
+//export FLBPluginExitCtx
+func FLBPluginExitCtx(ctx unsafe.Pointer) int {
+	pCtx := output.FLBPluginGetContext(ctx).(*PluginContext)
+
+	if pCtx.IsWindow() {
+		pCtx.FBWindow.Handle.Stop()
+	}
+	if pCtx.IsSketch() {
+		pCtx.FBSketch.Handle.Stop()
+	}
+	if pCtx.HasAnomalyDetection() {
+		pCtx.FBAnomaly.Handle.Stop()
+	}
+	if pCtx.IsHeartbeat() {
+		pCtx.FBHeartbeat.Handle.Stop()
+	}
+	if pCtx.Alerter != nil {
+		pCtx.Alerter.Stop()
+	}
+	if pCtx.Notifier != nil {
+		pCtx.Notifier.Stop()
+	}
+	if pCtx.EMFWriter != nil {
+		if err := pCtx.EMFWriter.Close(); err != nil {
+			level.Error(pCtx.Logger).Log("msg", "Could not close emf_output", "output", pCtx.EMFOutput, "err", err)
+		}
+	}
+	return output.FLB_OK
+}
+
 func main() {
 }
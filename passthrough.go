@@ -0,0 +1,241 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// tagPattern $TAG is the whole fluent bit tag, $TAG[n] its nth dot separated part counting from 0
+var tagPattern = regexp.MustCompile(`\$TAG(\[(\d+)\])?`)
+
+// ExpandTag Replace $TAG and $TAG[n] in s with the tag, or a part of it
+func ExpandTag(s, tag string) string {
+	parts := strings.Split(tag, ".")
+	return tagPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := tagPattern.FindStringSubmatch(m)
+		if len(sub[2]) == 0 {
+			return tag
+		}
+		i, _ := strconv.Atoi(sub[2])
+		if i >= len(parts) {
+			return ""
+		}
+		return parts[i]
+	})
+}
+
+type passthroughSeries struct {
+	metric *dto.Metric
+	seen   time.Time
+}
+
+type passthroughFamily struct {
+	help   string
+	typ    dto.MetricType
+	series map[string]*passthroughSeries
+}
+
+// PassthroughStore Holds the latest value of every series parsed from Prometheus exposition text
+// carried in records.  It is gathered alongside the plugin registry, relaying the metrics as they were logged.
+type PassthroughStore struct {
+	mu          sync.Mutex
+	labels      prometheus.Labels
+	tagLabels   map[string]string
+	ttl         time.Duration
+	maxSeries   int
+	seriesCount int
+	families    map[string]*passthroughFamily
+	ParseErrors prometheus.Counter
+	Dropped     prometheus.Counter
+}
+
+type FBPassthrough struct {
+	Handle *PassthroughStore
+}
+
+func (f *FBPassthrough) NewMetric(p *PluginContext) {
+	f.Handle = &PassthroughStore{
+		labels:    p.ConstantLabels,
+		tagLabels: p.Passthrough.TagLabels,
+		ttl:       p.Passthrough.TTL,
+		maxSeries: p.Passthrough.MaxSeries,
+		families:  make(map[string]*passthroughFamily),
+		ParseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.Name + "_parse_errors_total",
+			Help:        "Records whose " + p.Passthrough.Key + " field could not be parsed as Prometheus exposition text",
+			ConstLabels: p.ConstantLabels,
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.Name + "_dropped_series_total",
+			Help:        "Relayed series dropped because " + p.Name + " already holds its maximum number of series",
+			ConstLabels: p.ConstantLabels,
+		}),
+	}
+}
+
+// Ingest Parse exposition text and store its series with the record labels, constant labels and
+// tag labels added.  Added labels replace labels of the same name already on the series.  A job
+// label on a logged series is renamed exported_job, as Prometheus does, since the push sets job itself.
+func (s *PassthroughStore) Ingest(text, tag string, labels prometheus.Labels) error {
+	if !strings.HasSuffix(text, "\n") {
+		// The text parser needs every sample line terminated
+		text += "\n"
+	}
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		s.ParseErrors.Inc()
+		return err
+	}
+
+	extra := make(map[string]string, len(s.labels)+len(labels)+len(s.tagLabels))
+	for k, v := range s.labels {
+		extra[k] = v
+	}
+	for k, v := range labels {
+		extra[k] = v
+	}
+	for k, v := range s.tagLabels {
+		extra[k] = ExpandTag(v, tag)
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, mf := range parsed {
+		f, ok := s.families[name]
+		if !ok || f.typ != mf.GetType() {
+			if ok {
+				// The family changed type, start it over
+				s.seriesCount -= len(f.series)
+			}
+			f = &passthroughFamily{typ: mf.GetType(), series: make(map[string]*passthroughSeries)}
+			s.families[name] = f
+		}
+		f.help = mf.GetHelp()
+
+		for _, m := range mf.GetMetric() {
+			exportGroupingLabels(m)
+			relabel(m, extra)
+			// The push gateway rejects samples carrying their own timestamp
+			m.TimestampMs = nil
+
+			key := seriesKey(m.GetLabel())
+			if _, ok := f.series[key]; !ok {
+				if s.maxSeries > 0 && s.seriesCount >= s.maxSeries {
+					s.Dropped.Inc()
+					continue
+				}
+				s.seriesCount++
+			}
+			f.series[key] = &passthroughSeries{metric: m, seen: now}
+		}
+	}
+	return nil
+}
+
+// exportGroupingLabels Rename labels the pusher sets itself, Ex. job into exported_job, prefixing again
+// while the name is taken, and keep the label pairs sorted by name
+func exportGroupingLabels(m *dto.Metric) {
+	taken := make(map[string]bool, len(m.Label))
+	for _, l := range m.Label {
+		taken[l.GetName()] = true
+	}
+	renamed := false
+	for _, l := range m.Label {
+		if !pushGroupingLabel(l.GetName()) {
+			continue
+		}
+		name := "exported_" + l.GetName()
+		for taken[name] {
+			name = "exported_" + name
+		}
+		taken[name] = true
+		l.Name = proto.String(name)
+		renamed = true
+	}
+	if renamed {
+		sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+	}
+}
+
+// relabel Set the extra labels on m, keeping the label pairs sorted by name
+func relabel(m *dto.Metric, extra map[string]string) {
+	if len(extra) == 0 {
+		return
+	}
+	pairs := make([]*dto.LabelPair, 0, len(m.Label)+len(extra))
+	for _, l := range m.Label {
+		if _, ok := extra[l.GetName()]; !ok {
+			pairs = append(pairs, l)
+		}
+	}
+	for k, v := range extra {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(k), Value: proto.String(v)})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	m.Label = pairs
+}
+
+func seriesKey(pairs []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, l := range pairs {
+		b.WriteString(l.GetName() + "\xff" + l.GetValue() + "\xff")
+	}
+	return b.String()
+}
+
+// Gather Implements prometheus.Gatherer, expiring series not refreshed within the TTL
+func (s *PassthroughStore) Gather() ([]*dto.MetricFamily, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.families))
+	for name, f := range s.families {
+		for key, ps := range f.series {
+			if s.ttl > 0 && now.Sub(ps.seen) > s.ttl {
+				delete(f.series, key)
+				s.seriesCount--
+			}
+		}
+		if len(f.series) == 0 {
+			delete(s.families, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		f := s.families[name]
+		mf := &dto.MetricFamily{Name: proto.String(name), Type: f.typ.Enum()}
+		if len(f.help) != 0 {
+			mf.Help = proto.String(f.help)
+		}
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		// Map order would make every push list the series differently
+		sort.Strings(keys)
+		for _, key := range keys {
+			mf.Metric = append(mf.Metric, f.series[key].metric)
+		}
+		out = append(out, mf)
+	}
+	return out, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestExpandTag(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"$TAG", "batch.nightly_export"},
+		{"$TAG[0]", "batch"},
+		{"$TAG[1]", "nightly_export"},
+		{"$TAG[2]", ""},
+		{"run-$TAG[1]", "run-nightly_export"},
+		{"static", "static"},
+	}
+	for _, tt := range tests {
+		if got := ExpandTag(tt.in, "batch.nightly_export"); got != tt.want {
+			t.Errorf("ExpandTag(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func newPassthroughStore(maxSeries int, ttl time.Duration) *PassthroughStore {
+	p := &PluginContext{}
+	p.Name = "test_passthrough"
+	p.ConstantLabels = prometheus.Labels{"env": "prod"}
+	p.Passthrough.TagLabels = map[string]string{"batch": "$TAG[1]"}
+	p.Passthrough.MaxSeries = maxSeries
+	p.Passthrough.TTL = ttl
+	var f FBPassthrough
+	f.NewMetric(p)
+	return f.Handle
+}
+
+func TestPassthroughStoreIngest(t *testing.T) {
+	tests := []struct {
+		name        string
+		texts       []string
+		labels      prometheus.Labels
+		want        string
+		parseErrors float64
+		dropped     float64
+	}{
+		{
+			name:  "labels added and timestamp dropped",
+			texts: []string{"# HELP rows_total Rows exported\n# TYPE rows_total counter\nrows_total{table=\"a\"} 10 1600000000000"},
+			want: `
+# HELP rows_total Rows exported
+# TYPE rows_total counter
+rows_total{batch="nightly_export",env="prod",table="a"} 10
+`,
+		},
+		{
+			name:   "added labels replace logged ones",
+			texts:  []string{"# TYPE rows_total counter\nrows_total{env=\"dev\",host=\"x\"} 1"},
+			labels: prometheus.Labels{"host": "web1"},
+			want: `
+# HELP rows_total
+# TYPE rows_total counter
+rows_total{batch="nightly_export",env="prod",host="web1"} 1
+`,
+		},
+		{
+			name:  "logged job renamed",
+			texts: []string{"# TYPE up gauge\nup{job=\"export\",exported_job=\"old\"} 1"},
+			want: `
+# HELP up
+# TYPE up gauge
+up{batch="nightly_export",env="prod",exported_exported_job="export",exported_job="old"} 1
+`,
+		},
+		{
+			name:  "latest value kept",
+			texts: []string{"# TYPE rows gauge\nrows 1", "# TYPE rows gauge\nrows 2"},
+			want: `
+# HELP rows
+# TYPE rows gauge
+rows{batch="nightly_export",env="prod"} 2
+`,
+		},
+		{
+			name:  "type change starts the family over",
+			texts: []string{"# TYPE rows gauge\nrows{a=\"1\"} 1", "# TYPE rows counter\nrows{a=\"2\"} 2"},
+			want: `
+# HELP rows
+# TYPE rows counter
+rows{a="2",batch="nightly_export",env="prod"} 2
+`,
+		},
+		{
+			name:    "series over the cap dropped",
+			texts:   []string{"# TYPE rows gauge\nrows{a=\"1\"} 1\nrows{a=\"2\"} 2\nrows{a=\"3\"} 3"},
+			dropped: 1,
+			want: `
+# HELP rows
+# TYPE rows gauge
+rows{a="1",batch="nightly_export",env="prod"} 1
+rows{a="2",batch="nightly_export",env="prod"} 2
+`,
+		},
+		{
+			name:        "unparseable text",
+			texts:       []string{"rows{a=} 1"},
+			parseErrors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPassthroughStore(2, 0)
+			for _, text := range tt.texts {
+				s.Ingest(text, "batch.nightly_export", tt.labels)
+			}
+			if err := testutil.GatherAndCompare(s, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+			if got := testutil.ToFloat64(s.ParseErrors); got != tt.parseErrors {
+				t.Errorf("parse errors = %v, want %v", got, tt.parseErrors)
+			}
+			if got := testutil.ToFloat64(s.Dropped); got != tt.dropped {
+				t.Errorf("dropped = %v, want %v", got, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestPassthroughStoreTTL(t *testing.T) {
+	s := newPassthroughStore(2, time.Minute)
+	s.Ingest("# TYPE rows gauge\nrows{a=\"1\"} 1\nrows{a=\"2\"} 2", "batch.x", nil)
+
+	s.mu.Lock()
+	s.families["rows"].series[seriesKeyOf(t, s, "1")].seen = time.Now().Add(-2 * time.Minute)
+	s.mu.Unlock()
+
+	families, err := s.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || len(families[0].Metric) != 1 {
+		t.Fatalf("gathered %v, want only the refreshed series", families)
+	}
+	// The expired series frees its place under the cap
+	s.Ingest("# TYPE rows gauge\nrows{a=\"3\"} 3", "batch.x", nil)
+	if got := testutil.ToFloat64(s.Dropped); got != 0 {
+		t.Errorf("dropped = %v, want 0", got)
+	}
+}
+
+// seriesKeyOf Key of the stored rows series whose a label is v
+func seriesKeyOf(t *testing.T, s *PassthroughStore, v string) string {
+	for key, ps := range s.families["rows"].series {
+		for _, l := range ps.metric.Label {
+			if l.GetName() == "a" && l.GetValue() == v {
+				return key
+			}
+		}
+	}
+	t.Fatalf("no rows series with a=%q", v)
+	return ""
+}
+
+func TestPassthroughPushWithLoggedJob(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	s := newPassthroughStore(10, 0)
+	if err := s.Ingest("# TYPE up gauge\nup{job=\"export\"} 1", "batch.x", nil); err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s.ParseErrors, s.Dropped)
+
+	pusher := push.New(srv.URL, "fluentbit").Gatherer(prometheus.Gatherers{registry, s}).Format(expfmt.FmtText)
+	if err := pusher.Add(); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if !strings.Contains(body, "exported_job") {
+		t.Errorf("pushed body lacks the renamed job label: %q", body)
+	}
+}
+
+func TestSetMetricPassthroughTagLabelsRejectsJob(t *testing.T) {
+	tests := []struct {
+		name      string
+		tagLabels string
+		constant  prometheus.Labels
+		wantPanic bool
+	}{
+		{name: "tag labels", tagLabels: `{"batch":"$TAG[1]"}`},
+		{name: "job tag label", tagLabels: `{"job":"$TAG[1]"}`, wantPanic: true},
+		{name: "job constant label", constant: prometheus.Labels{"job": "x"}, wantPanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PluginContext{}
+			p.ConstantLabels = tt.constant
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+			p.SetMetricPassthroughTagLabels(tt.tagLabels, log.NewNopLogger())
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PatternMatcher Evaluates a set of named regexes against a field.
+// The patterns are also compiled into a single alternation that rejects records matching none of
+// them in one scan, so only records with a hit pay for running the patterns one by one.
+type PatternMatcher struct {
+	names    []string
+	res      []*regexp.Regexp
+	combined *regexp.Regexp
+}
+
+// NewPatternMatcher Compile name:regex definitions, Ex. timeout:(?i)timed out
+func NewPatternMatcher(definitions []string) (*PatternMatcher, error) {
+	m := &PatternMatcher{}
+	alternatives := make([]string, 0, len(definitions))
+	names := make(map[string]bool, len(definitions))
+
+	for _, d := range definitions {
+		i := strings.Index(d, ":")
+		if i <= 0 || i == len(d)-1 {
+			return nil, fmt.Errorf("pattern %q is not name:regex", d)
+		}
+		name := d[:i]
+		if names[name] {
+			return nil, fmt.Errorf("pattern %q is defined more than once", name)
+		}
+		names[name] = true
+
+		re, err := regexp.Compile(d[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", name, err)
+		}
+		m.names = append(m.names, name)
+		m.res = append(m.res, re)
+		// Flags such as (?i) stay scoped to their own group
+		alternatives = append(alternatives, "(?:"+d[i+1:]+")")
+	}
+
+	m.combined = regexp.MustCompile(strings.Join(alternatives, "|"))
+	return m, nil
+}
+
+// Match Return the names of the patterns matching s, in configuration order.  Every pattern is
+// judged on its own, so overlapping or identical patterns all count.
+func (m *PatternMatcher) Match(s string) []string {
+	if !m.combined.MatchString(s) {
+		return nil
+	}
+
+	var matched []string
+	for n, re := range m.res {
+		if re.MatchString(s) {
+			matched = append(matched, m.names[n])
+		}
+	}
+	return matched
+}
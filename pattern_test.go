@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewPatternMatcherErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []string
+	}{
+		{"missing name", []string{":OutOfMemory"}},
+		{"missing regex", []string{"oom:"}},
+		{"no separator", []string{"oom"}},
+		{"invalid regex", []string{"bad:(unclosed"}},
+		{"duplicate name", []string{"oom:OutOfMemory", "oom:OOMKilled"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPatternMatcher(tt.definitions); err == nil {
+				t.Errorf("NewPatternMatcher(%q) succeeded, want an error", tt.definitions)
+			}
+		})
+	}
+}
+
+func TestPatternMatcherMatch(t *testing.T) {
+	m, err := NewPatternMatcher([]string{
+		"oom:OutOfMemory",
+		"timeout:(?i)timed out",
+		"panic:^panic:",
+		"status:status=(5\\d\\d)",
+		"colon:a:b",
+		"error:(?i)error",
+		"db_error:(?i)database error",
+		"fatal:FATAL",
+		"fatal_copy:FATAL",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{"nothing", "all good", nil},
+		{"single", "java.lang.OutOfMemory thrown", []string{"oom"}},
+		{"flag stays in its group", "request TIMED OUT", []string{"timeout"}},
+		{"flag does not leak", "outofmemory", nil},
+		{"anchor at start", "panic: nil map", []string{"panic"}},
+		{"anchor elsewhere", "no panic: here", nil},
+		{"several in configuration order", "timed out after OutOfMemory", []string{"oom", "timeout"}},
+		{"inner groups keep numbering", "status=503 timed out", []string{"timeout", "status"}},
+		{"colon in regex", "a:b", []string{"colon"}},
+		{"repeated match counted once", "OutOfMemory OutOfMemory", []string{"oom"}},
+		{"overlapping matches all counted", "Database error on commit", []string{"error", "db_error"}},
+		{"identical patterns both counted", "FATAL disk full", []string{"fatal", "fatal_copy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Match(tt.message); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%q) = %v, want %v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
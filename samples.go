@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Sample Single flattened series value taken from a gatherer
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	// Cumulative Counters, and the _count, _sum and _bucket of summaries and histograms, only grow until reset
+	Cumulative bool
+}
+
+// key Identify the series, labels sorted so the key is stable
+func (s Sample) key() string {
+	names := make([]string, 0, len(s.Labels))
+	for n := range s.Labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(s.Name)
+	for _, n := range names {
+		b.WriteString("\xff" + n + "=" + s.Labels[n])
+	}
+	return b.String()
+}
+
+// GatherSamples Flatten every family of the gatherer.  Summaries and histograms contribute
+// <name>_count and <name>_sum, histograms also <name>_bucket with an le label.
+func GatherSamples(g prometheus.Gatherer) ([]Sample, error) {
+	families, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, f := range families {
+		name := f.GetName()
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetCounter().GetValue(), Cumulative: true})
+			case dto.MetricType_GAUGE:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetGauge().GetValue()})
+			case dto.MetricType_UNTYPED:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetUntyped().GetValue()})
+			case dto.MetricType_SUMMARY:
+				samples = append(samples,
+					Sample{Name: name + "_count", Labels: labels, Value: float64(m.GetSummary().GetSampleCount()), Cumulative: true},
+					Sample{Name: name + "_sum", Labels: labels, Value: m.GetSummary().GetSampleSum(), Cumulative: true})
+			case dto.MetricType_HISTOGRAM:
+				samples = append(samples,
+					Sample{Name: name + "_count", Labels: labels, Value: float64(m.GetHistogram().GetSampleCount()), Cumulative: true},
+					Sample{Name: name + "_sum", Labels: labels, Value: m.GetHistogram().GetSampleSum(), Cumulative: true})
+				for _, b := range m.GetHistogram().GetBucket() {
+					bl := make(map[string]string, len(labels)+1)
+					for k, v := range labels {
+						bl[k] = v
+					}
+					bl["le"] = strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)
+					samples = append(samples, Sample{Name: name + "_bucket", Labels: bl, Value: float64(b.GetCumulativeCount()), Cumulative: true})
+				}
+			}
+		}
+	}
+	return samples, nil
+}
+
+// MetricSelector Metric name with optional label equality matchers, Ex. errors_total{status="500"}
+type MetricSelector struct {
+	Name     string
+	Matchers map[string]string
+}
+
+func ParseMetricSelector(s string) (MetricSelector, error) {
+	sel := MetricSelector{Matchers: map[string]string{}}
+
+	i := strings.Index(s, "{")
+	if i < 0 {
+		sel.Name = s
+		return sel, nil
+	}
+	if !strings.HasSuffix(s, "}") {
+		return sel, fmt.Errorf("selector %q is missing a closing brace", s)
+	}
+	sel.Name = s[:i]
+	for _, m := range SplitUnquoted(s[i+1:len(s)-1], func(r rune) bool { return r == ',' }) {
+		m = strings.TrimSpace(m)
+		if len(m) == 0 {
+			continue
+		}
+		j := strings.Index(m, "=")
+		if j <= 0 {
+			return sel, fmt.Errorf("selector %q matcher %q is not label=\"value\"", s, m)
+		}
+		sel.Matchers[strings.TrimSpace(m[:j])] = ConfigKeyQuoteTrim(strings.TrimSpace(m[j+1:]))
+	}
+	return sel, nil
+}
+
+// SplitUnquoted Split s where sep holds, except inside {} braces and double quoted values, dropping
+// empty parts.  Selectors such as errors_total{msg="timed out, retrying"} stay in one piece.
+func SplitUnquoted(s string, sep func(r rune) bool) []string {
+	var parts []string
+	var quoted, escaped bool
+	depth, start := 0, 0
+
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '{':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case depth == 0 && sep(r):
+			if i > start {
+				parts = append(parts, s[start:i])
+			}
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	if len(s) > start {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+func (sel MetricSelector) Matches(s Sample) bool {
+	if s.Name != sel.Name {
+		return false
+	}
+	for k, v := range sel.Matchers {
+		if s.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type ratePoint struct {
+	at    time.Time
+	value float64
+}
+
+// MetricExpr Selector evaluated either as is or as a per second rate, Ex. rate(errors_total[1m])
+type MetricExpr struct {
+	Selector MetricSelector
+	Rate     bool
+	Window   time.Duration
+	history  map[string][]ratePoint
+}
+
+// ParseMetricExpr Parse selector, rate(selector) or rate(selector[window]).
+// Without a window the rate covers the time since the previous evaluation.
+func ParseMetricExpr(s string) (*MetricExpr, error) {
+	e := &MetricExpr{history: make(map[string][]ratePoint)}
+
+	if strings.HasPrefix(s, "rate(") && strings.HasSuffix(s, ")") {
+		e.Rate = true
+		s = s[len("rate(") : len(s)-1]
+
+		if strings.HasSuffix(s, "]") {
+			i := strings.LastIndex(s, "[")
+			if i < 0 {
+				return nil, fmt.Errorf("rate window in %q is missing an opening bracket", s)
+			}
+			w, err := time.ParseDuration(s[i+1 : len(s)-1])
+			if err != nil {
+				return nil, err
+			}
+			e.Window = w
+			s = s[:i]
+		}
+	}
+
+	sel, err := ParseMetricSelector(s)
+	if err != nil {
+		return nil, err
+	}
+	e.Selector = sel
+	return e, nil
+}
+
+// Eval Return the current value of every matching series.  Rates need two evaluations before they report.
+func (e *MetricExpr) Eval(samples []Sample, now time.Time) []Sample {
+	var out []Sample
+	seen := make(map[string]bool)
+
+	for _, s := range samples {
+		if !e.Selector.Matches(s) {
+			continue
+		}
+		if !e.Rate {
+			out = append(out, s)
+			continue
+		}
+
+		key := s.key()
+		seen[key] = true
+
+		points := append(e.history[key], ratePoint{at: now, value: s.Value})
+		if e.Window > 0 {
+			// Keep the newest point at or before the window start so the rate spans the whole window
+			start := now.Add(-e.Window)
+			for len(points) > 2 && !points[1].at.After(start) {
+				points = points[1:]
+			}
+		} else if len(points) > 2 {
+			points = points[len(points)-2:]
+		}
+		e.history[key] = points
+
+		if len(points) < 2 {
+			continue
+		}
+		var increase float64
+		for i := 1; i < len(points); i++ {
+			if d := points[i].value - points[i-1].value; d >= 0 {
+				increase += d
+			} else {
+				// Counter reset, as Prometheus increase() treats it
+				increase += points[i].value
+			}
+		}
+		elapsed := points[len(points)-1].at.Sub(points[0].at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		out = append(out, Sample{Name: s.Name, Labels: s.Labels, Value: increase / elapsed})
+	}
+
+	for key := range e.history {
+		if !seen[key] {
+			delete(e.history, key)
+		}
+	}
+	return out
+}
+
+// ParseThreshold Parse the EXPR OP THRESHOLD triple shared by alert rules and webhook conditions.
+// The threshold may carry a /s suffix for readability.
+func ParseThreshold(expr, op, threshold string) (*MetricExpr, float64, error) {
+	e, err := ParseMetricExpr(expr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return nil, 0, fmt.Errorf("unknown operator %q", op)
+	}
+
+	t, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "/s"), 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return e, t, nil
+}
+
+// compareThreshold Apply a comparison operator: > >= < <= == !=
+func compareThreshold(v float64, op string, threshold float64) bool {
+	switch op {
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	case "==":
+		return v == threshold
+	case "!=":
+		return v != threshold
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGatherSamples(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors_total", Help: "test"}, []string{"code"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth", Help: "test", ConstLabels: prometheus.Labels{"queue": "orders"}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "latency_seconds", Help: "test"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size_bytes", Help: "test", Buckets: []float64{10, 100}})
+	registry.MustRegister(counter, gauge, summary, histogram)
+
+	counter.WithLabelValues("500").Add(3)
+	gauge.Set(7)
+	summary.Observe(0.5)
+	summary.Observe(1.5)
+	histogram.Observe(5)
+	histogram.Observe(50)
+	histogram.Observe(500)
+
+	samples, err := GatherSamples(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64, len(samples))
+	for _, s := range samples {
+		got[s.key()] = s.Value
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"errors_total", map[string]string{"code": "500"}, 3},
+		{"queue_depth", map[string]string{"queue": "orders"}, 7},
+		{"latency_seconds_count", map[string]string{}, 2},
+		{"latency_seconds_sum", map[string]string{}, 2},
+		{"size_bytes_count", map[string]string{}, 3},
+		{"size_bytes_sum", map[string]string{}, 555},
+		{"size_bytes_bucket", map[string]string{"le": "10"}, 1},
+		{"size_bytes_bucket", map[string]string{"le": "100"}, 2},
+	}
+	for _, tt := range tests {
+		key := Sample{Name: tt.name, Labels: tt.labels}.key()
+		v, ok := got[key]
+		if !ok {
+			t.Errorf("no sample %s%v", tt.name, tt.labels)
+			continue
+		}
+		if v != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, v, tt.want)
+		}
+	}
+	// Summary quantiles are not flattened
+	if len(samples) != len(tests) {
+		t.Errorf("%d samples, want %d", len(samples), len(tests))
+	}
+}
+
+func TestSplitUnquoted(t *testing.T) {
+	comma := func(r rune) bool { return r == ',' }
+
+	tests := []struct {
+		name string
+		in   string
+		sep  func(rune) bool
+		want []string
+	}{
+		{"fields", "A  b > 1", unicode.IsSpace, []string{"A", "b", ">", "1"}},
+		{"braces keep spaces", `A errors_total{msg="a b", x="y"} > 1`, unicode.IsSpace, []string{"A", `errors_total{msg="a b", x="y"}`, ">", "1"}},
+		{"quotes keep commas", `msg="a, b",code="5"`, comma, []string{`msg="a, b"`, `code="5"`}},
+		{"escaped quote", `msg="say \"a, b\"",code="5"`, comma, []string{`msg="say \"a, b\""`, `code="5"`}},
+		{"empty parts dropped", ",a,,b,", comma, []string{"a", "b"}},
+		{"empty", "", comma, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitUnquoted(tt.in, tt.sep); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitUnquoted(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetricSelector(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantErr  bool
+		name     string
+		matchers map[string]string
+	}{
+		{in: "errors_total", name: "errors_total", matchers: map[string]string{}},
+		{in: `errors_total{code="500"}`, name: "errors_total", matchers: map[string]string{"code": "500"}},
+		{in: `errors_total{ code = "500" , msg="a, b" }`, name: "errors_total", matchers: map[string]string{"code": "500", "msg": "a, b"}},
+		{in: `errors_total{code="500"`, wantErr: true},
+		{in: `errors_total{"500"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			sel, err := ParseMetricSelector(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if sel.Name != tt.name || !reflect.DeepEqual(sel.Matchers, tt.matchers) {
+				t.Errorf("got %s %v, want %s %v", sel.Name, sel.Matchers, tt.name, tt.matchers)
+			}
+		})
+	}
+}
+
+func TestMetricExprRate(t *testing.T) {
+	e, err := ParseMetricExpr("rate(errors_total[1m])")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(0, 0)
+
+	tests := []struct {
+		at    time.Duration
+		value float64
+		want  []float64
+	}{
+		{0, 0, nil},
+		{30 * time.Second, 30, []float64{1}},
+		{60 * time.Second, 90, []float64{1.5}},
+		// A counter reset counts the new value as the increase
+		{90 * time.Second, 15, []float64{(60 + 15) / 60.0}},
+	}
+	for _, tt := range tests {
+		out := e.Eval([]Sample{{Name: "errors_total", Labels: map[string]string{}, Value: tt.value}}, start.Add(tt.at))
+		var got []float64
+		for _, s := range out {
+			got = append(got, s.Value)
+		}
+		sort.Float64s(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("at %v rate = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type sequenceStream struct {
+	key  string
+	high uint64
+	// first Lowest value of the current run, values below it were never expected
+	first uint64
+	// seen Ring bitmap of the window of values at or below high, bit v%window set once v arrived
+	seen []uint64
+	// behind Value further back than the window, either a straggler or the start of a new run.
+	// The next value decides which.
+	behind    uint64
+	hasBehind bool
+}
+
+// sequenceMaxJumpGaps Gaps a single forward jump adds at most, so one corrupt sequence number cannot swamp the counter
+const sequenceMaxJumpGaps = 1 << 20
+
+func (s *sequenceStream) bit(v uint64) (int, uint64) {
+	i := v % uint64(len(s.seen)*64)
+	return int(i / 64), 1 << (i % 64)
+}
+
+// SequenceTracker Follows the sequence numbers stamped by producers, per stream, and counts the
+// values skipped, repeated and delivered late.  Streams live in a bounded LRU table.
+type SequenceTracker struct {
+	mu         sync.Mutex
+	labelNames []string
+	window     uint64
+	maxStreams int
+	streams    map[string]*list.Element
+	order      *list.List
+	Gaps       *prometheus.CounterVec
+	Duplicates *prometheus.CounterVec
+	OutOfOrder *prometheus.CounterVec
+	Resets     *prometheus.CounterVec
+}
+
+type FBSequence struct {
+	Handle *SequenceTracker
+}
+
+func (s *FBSequence) NewMetric(p *PluginContext) {
+	counter := func(suffix, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        p.Name + suffix,
+			Help:        p.Help + " (" + help + ")",
+			ConstLabels: p.ConstantLabels,
+		}, p.VariableLabels)
+	}
+
+	// Round the window up to whole bitmap words
+	words := (p.Sequence.Window + 63) / 64
+
+	s.Handle = &SequenceTracker{
+		labelNames: p.VariableLabels,
+		window:     uint64(words * 64),
+		maxStreams: p.Sequence.MaxStreams,
+		streams:    make(map[string]*list.Element),
+		order:      list.New(),
+		Gaps:       counter("_gaps_total", "sequence values not arrived within the window"),
+		Duplicates: counter("_duplicates_total", "sequence values seen more than once"),
+		OutOfOrder: counter("_out_of_order_total", "sequence values arriving after a higher one"),
+		Resets:     counter("_resets_total", "sequences restarting further back than the window"),
+	}
+}
+
+// ParseSequence Read a non-negative integral sequence number from a record value
+func ParseSequence(v interface{}) (uint64, error) {
+	switch n := v.(type) {
+	case uint64:
+		return n, nil
+	case int64:
+		if n >= 0 {
+			return uint64(n), nil
+		}
+	case float64:
+		if n >= 0 && n == math.Trunc(n) && n < math.MaxUint64 {
+			return uint64(n), nil
+		}
+	case nil:
+		return 0, fmt.Errorf("sequence field missing")
+	default:
+		return strconv.ParseUint(fmt.Sprintf("%v", v), 10, 64)
+	}
+	return 0, fmt.Errorf("sequence value %v is not a non-negative integer", v)
+}
+
+// Observe Feed the sequence number of a record of stream into the tracker.  A skipped value only
+// counts as a gap once it falls out of the window without having arrived, so late records are not gaps.
+// A value further back than the window is held until the next one: a next value continuing from it
+// means the producer started over, otherwise it was a straggler and counts as out of order.
+func (t *SequenceTracker) Observe(labels prometheus.Labels, stream string, seq uint64) {
+	key := labelsKey(append(labelValues(labels, t.labelNames), stream))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.streams[key]
+	if !ok {
+		for t.maxStreams > 0 && t.order.Len() >= t.maxStreams {
+			oldest := t.order.Back()
+			t.order.Remove(oldest)
+			delete(t.streams, oldest.Value.(*sequenceStream).key)
+		}
+		s := &sequenceStream{key: key, seen: make([]uint64, t.window/64)}
+		s.restart(seq)
+		t.streams[key] = t.order.PushFront(s)
+		return
+	}
+	t.order.MoveToFront(e)
+	s := e.Value.(*sequenceStream)
+
+	inWindow := seq > s.high || s.high-seq < t.window
+	if s.hasBehind {
+		s.hasBehind = false
+		if !inWindow && seq > s.behind && seq-s.behind <= t.window {
+			t.Resets.With(labels).Inc()
+			s.restart(s.behind)
+			inWindow = true
+		} else {
+			t.OutOfOrder.With(labels).Inc()
+		}
+	}
+
+	switch {
+	case seq > s.high:
+		// Each value entering the window takes the bit of the one leaving it.  A jump past the whole
+		// window clears it, and the values jumped over that are already behind the new one are gaps.
+		var missing, jumped uint64
+		end := seq
+		if seq-s.high > t.window {
+			end = s.high + t.window
+			jumped = seq - t.window - s.high
+			if jumped > sequenceMaxJumpGaps {
+				jumped = sequenceMaxJumpGaps
+			}
+		}
+		for v := s.high + 1; v <= end; v++ {
+			w, b := s.bit(v)
+			if v >= s.first+t.window && s.seen[w]&b == 0 {
+				missing++
+			}
+			s.seen[w] &^= b
+		}
+		if missing+jumped > 0 {
+			t.Gaps.With(labels).Add(float64(missing + jumped))
+		}
+		s.high = seq
+
+	case inWindow:
+		if w, b := s.bit(seq); s.seen[w]&b != 0 {
+			t.Duplicates.With(labels).Inc()
+			return
+		}
+		t.OutOfOrder.With(labels).Inc()
+
+	default:
+		s.behind = seq
+		s.hasBehind = true
+		return
+	}
+
+	w, b := s.bit(seq)
+	s.seen[w] |= b
+}
+
+// restart Begin a new run of the stream at seq
+func (s *sequenceStream) restart(seq uint64) {
+	for i := range s.seen {
+		s.seen[i] = 0
+	}
+	s.high = seq
+	s.first = seq
+	w, b := s.bit(seq)
+	s.seen[w] |= b
+}
+
+func (t *SequenceTracker) Describe(ch chan<- *prometheus.Desc) {
+	t.Gaps.Describe(ch)
+	t.Duplicates.Describe(ch)
+	t.OutOfOrder.Describe(ch)
+	t.Resets.Describe(ch)
+}
+
+func (t *SequenceTracker) Collect(ch chan<- prometheus.Metric) {
+	t.Gaps.Collect(ch)
+	t.Duplicates.Collect(ch)
+	t.OutOfOrder.Collect(ch)
+	t.Resets.Collect(ch)
+}
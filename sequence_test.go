@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSequence(t *testing.T) {
+	tests := []struct {
+		in      interface{}
+		want    uint64
+		wantErr bool
+	}{
+		{uint64(7), 7, false},
+		{int64(7), 7, false},
+		{float64(7), 7, false},
+		{"7", 7, false},
+		{[]byte("7"), 0, true},
+		{int64(-1), 0, true},
+		{float64(1.5), 0, true},
+		{"seven", 0, true},
+		{nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSequence(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSequence(%v) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSequence(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceTrackerObserve(t *testing.T) {
+	tests := []struct {
+		name       string
+		seqs       []uint64
+		gaps       float64
+		duplicates float64
+		outOfOrder float64
+		resets     float64
+	}{
+		{name: "in order", seqs: []uint64{1, 2, 3, 4}},
+		{name: "skipped value still in the window", seqs: []uint64{1, 2, 4}},
+		{name: "late value is not a gap", seqs: append([]uint64{1, 3, 2}, seqRange(4, 70)...), outOfOrder: 1},
+		{name: "missing value leaves the window", seqs: append([]uint64{1, 3}, seqRange(4, 70)...), gaps: 1},
+		{name: "late value after leaving the window", seqs: append(append([]uint64{10, 12}, seqRange(13, 76)...), 11), gaps: 1},
+		{name: "straggler followed by in-order values", seqs: append(append(append([]uint64{10, 12}, seqRange(13, 76)...), 11), 77, 78), gaps: 1, outOfOrder: 1},
+		{name: "duplicate", seqs: []uint64{1, 2, 2, 1}, duplicates: 2},
+		{name: "jump within the window", seqs: append([]uint64{1, 50}, seqRange(51, 120)...), gaps: 48},
+		// 3 to 936 are behind the new window at once, 937 leaves it when 1001 arrives
+		{name: "jump beyond the window", seqs: []uint64{1, 2, 1000, 1001}, gaps: 935},
+		{name: "jump just past the window", seqs: append([]uint64{1, 67}, seqRange(68, 131)...), gaps: 65},
+		{name: "huge jump capped", seqs: []uint64{1, 1 << 40}, gaps: sequenceMaxJumpGaps},
+		{name: "restart far back", seqs: []uint64{1000, 1001, 1, 2, 3}, resets: 1},
+		{name: "restart then loss in the new run", seqs: append([]uint64{1000, 1001, 1, 3}, seqRange(4, 70)...), gaps: 1, resets: 1},
+		{name: "values before the first are not gaps", seqs: seqRange(100, 300)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PluginContext{}
+			p.Name = "test_sequence"
+			p.Help = "test"
+			p.Sequence.Window = 64
+			p.Sequence.MaxStreams = 10
+			var s FBSequence
+			s.NewMetric(p)
+
+			labels := prometheus.Labels{}
+			for _, v := range tt.seqs {
+				s.Handle.Observe(labels, "a", v)
+			}
+
+			counters := []struct {
+				name string
+				c    *prometheus.CounterVec
+				want float64
+			}{
+				{"gaps", s.Handle.Gaps, tt.gaps},
+				{"duplicates", s.Handle.Duplicates, tt.duplicates},
+				{"out of order", s.Handle.OutOfOrder, tt.outOfOrder},
+				{"resets", s.Handle.Resets, tt.resets},
+			}
+			for _, c := range counters {
+				if got := testutil.ToFloat64(c.c.With(labels)); got != c.want {
+					t.Errorf("%s = %v, want %v", c.name, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSequenceTrackerStreams(t *testing.T) {
+	p := &PluginContext{}
+	p.Name = "test_sequence"
+	p.Help = "test"
+	p.Sequence.Window = 64
+	p.Sequence.MaxStreams = 2
+	var s FBSequence
+	s.NewMetric(p)
+
+	labels := prometheus.Labels{}
+	s.Handle.Observe(labels, "a", 1)
+	s.Handle.Observe(labels, "b", 500)
+	s.Handle.Observe(labels, "a", 2)
+	// Evicts b, the least recently seen
+	s.Handle.Observe(labels, "c", 1)
+	s.Handle.Observe(labels, "b", 1)
+
+	if got := testutil.ToFloat64(s.Handle.Resets.With(labels)); got != 0 {
+		t.Errorf("resets = %v, want 0 for separate streams", got)
+	}
+	if got := len(s.Handle.streams); got != 2 {
+		t.Errorf("%d streams, want 2", got)
+	}
+}
+
+// seqRange Values from lo to hi inclusive
+func seqRange(lo, hi uint64) []uint64 {
+	var r []uint64
+	for v := lo; v <= hi; v++ {
+		r = append(r, v)
+	}
+	return r
+}
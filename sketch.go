@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ddSketchMaxBins Bound on stored bins per sign; the lowest bins collapse once it is reached
+const ddSketchMaxBins = 2048
+
+// DDSketch Mergeable quantile sketch with relative accuracy guarantees.
+// See https://arxiv.org/abs/1908.10693
+type DDSketch struct {
+	RelativeAccuracy float64         `json:"relative_accuracy"`
+	Gamma            float64         `json:"gamma"`
+	ZeroCount        float64         `json:"zero_count"`
+	Positive         map[int]float64 `json:"positive"`
+	Negative         map[int]float64 `json:"negative"`
+	Count            float64         `json:"count"`
+	Sum              float64         `json:"sum"`
+	logGamma         float64
+}
+
+func NewDDSketch(relativeAccuracy float64) *DDSketch {
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &DDSketch{
+		RelativeAccuracy: relativeAccuracy,
+		Gamma:            gamma,
+		Positive:         make(map[int]float64),
+		Negative:         make(map[int]float64),
+		logGamma:         math.Log(gamma),
+	}
+}
+
+func (d *DDSketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / d.logGamma))
+}
+
+// value Representative value of a bin, within the relative accuracy of anything stored in it
+func (d *DDSketch) value(i int) float64 {
+	return 2 * math.Pow(d.Gamma, float64(i)) / (d.Gamma + 1)
+}
+
+func (d *DDSketch) Add(v float64) {
+	switch {
+	case v > 0:
+		d.Positive[d.index(v)]++
+		collapse(d.Positive)
+	case v < 0:
+		d.Negative[d.index(-v)]++
+		collapse(d.Negative)
+	default:
+		d.ZeroCount++
+	}
+	d.Count++
+	d.Sum += v
+}
+
+// Merge Fold another sketch of the same relative accuracy into this one
+func (d *DDSketch) Merge(o *DDSketch) {
+	for k, c := range o.Positive {
+		d.Positive[k] += c
+	}
+	collapse(d.Positive)
+	for k, c := range o.Negative {
+		d.Negative[k] += c
+	}
+	collapse(d.Negative)
+	d.ZeroCount += o.ZeroCount
+	d.Count += o.Count
+	d.Sum += o.Sum
+}
+
+// collapse Fold the lowest bins together until the bin count is within bounds
+func collapse(bins map[int]float64) {
+	if len(bins) <= ddSketchMaxBins {
+		return
+	}
+	keys := sortedBins(bins)
+	target := keys[len(keys)-ddSketchMaxBins]
+	for _, k := range keys[:len(keys)-ddSketchMaxBins] {
+		bins[target] += bins[k]
+		delete(bins, k)
+	}
+}
+
+func sortedBins(bins map[int]float64) []int {
+	keys := make([]int, 0, len(bins))
+	for k := range bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Quantile Estimate the value at quantile q, NaN when the sketch is empty
+func (d *DDSketch) Quantile(q float64) float64 {
+	if d.Count == 0 {
+		return math.NaN()
+	}
+	rank := q * (d.Count - 1)
+
+	var seen float64
+	negative := sortedBins(d.Negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		seen += d.Negative[negative[i]]
+		if seen > rank {
+			return -d.value(negative[i])
+		}
+	}
+	seen += d.ZeroCount
+	if seen > rank {
+		return 0
+	}
+	positive := sortedBins(d.Positive)
+	for _, k := range positive {
+		seen += d.Positive[k]
+		if seen > rank {
+			return d.value(k)
+		}
+	}
+	return d.value(positive[len(positive)-1])
+}
+
+type sketchSeries struct {
+	labelValues []string
+	sketch      *DDSketch
+	// delta holds the observations not yet exported
+	delta *DDSketch
+}
+
+// SketchCollector Exports configured quantiles of per label set DDSketches as gauges
+type SketchCollector struct {
+	mu           sync.Mutex
+	name         string
+	labelNames   []string
+	constLabels  prometheus.Labels
+	accuracy     float64
+	quantiles    []float64
+	quantileDesc *prometheus.Desc
+	countDesc    *prometheus.Desc
+	sumDesc      *prometheus.Desc
+	series       map[string]*sketchSeries
+	exportURL    string
+	client       *http.Client
+	logger       log.Logger
+	stop         chan struct{}
+}
+
+type FBSketch struct {
+	Handle *SketchCollector
+}
+
+func (s *FBSketch) NewMetric(p *PluginContext) {
+	s.Handle = &SketchCollector{
+		name:         p.Name,
+		labelNames:   p.VariableLabels,
+		constLabels:  p.ConstantLabels,
+		accuracy:     p.Sketch.RelativeAccuracy,
+		quantiles:    p.Sketch.Quantiles,
+		quantileDesc: prometheus.NewDesc(p.Name, p.Help, append(append([]string{}, p.VariableLabels...), "quantile"), p.ConstantLabels),
+		countDesc:    prometheus.NewDesc(p.Name+"_count", p.Help+" (observations)", p.VariableLabels, p.ConstantLabels),
+		sumDesc:      prometheus.NewDesc(p.Name+"_sum", p.Help+" (sum of observations)", p.VariableLabels, p.ConstantLabels),
+		series:       make(map[string]*sketchSeries),
+		exportURL:    p.Sketch.ExportURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		logger:       p.Logger,
+		stop:         make(chan struct{}),
+	}
+
+	if len(p.Sketch.ExportURL) != 0 {
+		go s.Handle.run(p.Sketch.ExportInterval)
+	}
+}
+
+// run Export on a ticker, away from the flush path
+func (s *SketchCollector) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Export(); err != nil {
+				level.Error(s.logger).Log("msg", "Could not export sketches", "url", s.exportURL, "err", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop End the export goroutine when the plugin exits
+func (s *SketchCollector) Stop() {
+	close(s.stop)
+}
+
+func (s *SketchCollector) Observe(labels prometheus.Labels, v float64) {
+	values := labelValues(labels, s.labelNames)
+	key := labelsKey(values)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series, ok := s.series[key]
+	if !ok {
+		series = &sketchSeries{labelValues: values, sketch: NewDDSketch(s.accuracy), delta: NewDDSketch(s.accuracy)}
+		s.series[key] = series
+	}
+	series.sketch.Add(v)
+	if len(s.exportURL) != 0 {
+		series.delta.Add(v)
+	}
+}
+
+func (s *SketchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.quantileDesc
+	ch <- s.countDesc
+	ch <- s.sumDesc
+}
+
+func (s *SketchCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, series := range s.series {
+		for _, q := range s.quantiles {
+			lv := append(append([]string{}, series.labelValues...), strconv.FormatFloat(q, 'g', -1, 64))
+			ch <- prometheus.MustNewConstMetric(s.quantileDesc, prometheus.GaugeValue, series.sketch.Quantile(q), lv...)
+		}
+		ch <- prometheus.MustNewConstMetric(s.countDesc, prometheus.CounterValue, series.sketch.Count, series.labelValues...)
+		ch <- prometheus.MustNewConstMetric(s.sumDesc, prometheus.CounterValue, series.sketch.Sum, series.labelValues...)
+	}
+}
+
+type sketchExport struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Sketch *DDSketch         `json:"sketch"`
+}
+
+// Export POST the observations made since the previous successful export, so a central service can
+// merge them across instances without double counting.  A failed POST keeps them for the next attempt.
+func (s *SketchCollector) Export() error {
+	if len(s.exportURL) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	var keys []string
+	payload := make([]sketchExport, 0, len(s.series))
+	for key, series := range s.series {
+		if series.delta.Count == 0 {
+			continue
+		}
+		labels := make(map[string]string, len(s.constLabels)+len(s.labelNames))
+		for k, v := range s.constLabels {
+			labels[k] = v
+		}
+		for i, n := range s.labelNames {
+			labels[n] = series.labelValues[i]
+		}
+		payload = append(payload, sketchExport{Name: s.name, Labels: labels, Sketch: series.delta})
+		keys = append(keys, key)
+		series.delta = NewDDSketch(s.accuracy)
+	}
+	s.mu.Unlock()
+	if len(payload) == 0 {
+		return nil
+	}
+
+	err := s.post(payload)
+	if err != nil {
+		s.mu.Lock()
+		for i, key := range keys {
+			if series, ok := s.series[key]; ok {
+				series.delta.Merge(payload[i].Sketch)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *SketchCollector) post(payload []sketchExport) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.exportURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, s.exportURL)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDDSketchQuantile(t *testing.T) {
+	uniform := make([]float64, 1000)
+	for i := range uniform {
+		uniform[i] = float64(i + 1)
+	}
+	mixed := []float64{-100, -10, -1, 0, 0, 1, 10, 100, 1000}
+
+	tests := []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{"median of 1..1000", uniform, 0.5, 500},
+		{"p99 of 1..1000", uniform, 0.99, 990},
+		{"min of 1..1000", uniform, 0, 1},
+		{"max of 1..1000", uniform, 1, 1000},
+		{"negative low quantile", mixed, 0, -100},
+		{"zero bucket", mixed, 0.375, 0},
+		{"positive high quantile", mixed, 1, 1000},
+		{"single value", []float64{42}, 0.9, 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDDSketch(0.01)
+			for _, v := range tt.values {
+				d.Add(v)
+			}
+			got := d.Quantile(tt.q)
+			// The sketch guarantees the relative accuracy, rank rounding allows one more step
+			if math.Abs(got-tt.want) > 0.02*math.Abs(tt.want)+1e-9 {
+				t.Errorf("Quantile(%v) = %v, want %v within 2%%", tt.q, got, tt.want)
+			}
+		})
+	}
+
+	if got := NewDDSketch(0.01).Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("Quantile() of an empty sketch = %v, want NaN", got)
+	}
+}
+
+func TestDDSketchMerge(t *testing.T) {
+	a, b, all := NewDDSketch(0.01), NewDDSketch(0.01), NewDDSketch(0.01)
+	for i := -50; i <= 500; i++ {
+		v := float64(i) * 1.5
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		all.Add(v)
+	}
+
+	a.Merge(b)
+	if a.Count != all.Count || a.Sum != all.Sum || a.ZeroCount != all.ZeroCount {
+		t.Fatalf("merged count/sum/zero = %v/%v/%v, want %v/%v/%v", a.Count, a.Sum, a.ZeroCount, all.Count, all.Sum, all.ZeroCount)
+	}
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 1} {
+		if got, want := a.Quantile(q), all.Quantile(q); got != want {
+			t.Errorf("merged Quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestDDSketchCollapse(t *testing.T) {
+	d := NewDDSketch(0.001)
+	var max float64
+	for v := 1e-6; v < 1e12; v *= 1.01 {
+		d.Add(v)
+		max = v
+	}
+	if n := len(d.Positive); n > ddSketchMaxBins {
+		t.Errorf("%d positive bins, want at most %d", n, ddSketchMaxBins)
+	}
+	// Collapsing only merges the lowest bins, high quantiles keep their accuracy
+	if got := d.Quantile(1); math.Abs(got-max) > 0.002*max {
+		t.Errorf("Quantile(1) = %v, want %v within 0.2%%", got, max)
+	}
+}
+
+func TestSketchCollectorExport(t *testing.T) {
+	var received [][]sketchExport
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []sketchExport
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding export body: %v", err)
+		}
+		received = append(received, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.Name = "test_sketch"
+	p.Help = "test"
+	p.VariableLabels = []string{"route"}
+	p.ConstantLabels = prometheus.Labels{"instance": "1"}
+	p.Sketch.RelativeAccuracy = 0.01
+	p.Sketch.Quantiles = []float64{0.5}
+	// No export URL at construction keeps the ticker goroutine out of the test
+	var s FBSketch
+	s.NewMetric(p)
+	s.Handle.exportURL = srv.URL
+
+	observe := func(n int) {
+		for i := 0; i < n; i++ {
+			s.Handle.Observe(prometheus.Labels{"route": "/a"}, 10)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		observe   int
+		status    int
+		wantErr   bool
+		wantPosts int
+		wantCount float64
+	}{
+		{"first interval", 3, http.StatusOK, false, 1, 3},
+		{"only the delta is sent", 2, http.StatusOK, false, 2, 2},
+		{"nothing new, nothing posted", 0, http.StatusOK, false, 2, 0},
+		{"failed post", 4, http.StatusServiceUnavailable, true, 3, 4},
+		{"failed delta is resent", 1, http.StatusOK, false, 4, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observe(tt.observe)
+			status = tt.status
+
+			err := s.Handle.Export()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Export() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(received) != tt.wantPosts {
+				t.Fatalf("%d posts, want %d", len(received), tt.wantPosts)
+			}
+			if tt.wantCount == 0 {
+				return
+			}
+			last := received[len(received)-1]
+			if len(last) != 1 {
+				t.Fatalf("%d sketches posted, want 1", len(last))
+			}
+			if last[0].Sketch.Count != tt.wantCount {
+				t.Errorf("posted count = %v, want %v", last[0].Sketch.Count, tt.wantCount)
+			}
+			if last[0].Labels["route"] != "/a" || last[0].Labels["instance"] != "1" {
+				t.Errorf("posted labels = %v", last[0].Labels)
+			}
+		})
+	}
+
+	// The exported gauges keep the cumulative sketch
+	s.Handle.mu.Lock()
+	defer s.Handle.mu.Unlock()
+	if got := s.Handle.series[labelsKey([]string{"/a"})].sketch.Count; got != 10 {
+		t.Errorf("cumulative count = %v, want 10", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// sloBucketsPerWindow Resolution of the shortest window, longer windows reuse the same buckets
+const sloBucketsPerWindow = 10
+
+// Counts held per bucket of an SLO series
+const (
+	sloGood = iota
+	sloBad
+)
+
+type sloSeries struct {
+	labelValues []string
+	ring        *timeRing
+}
+
+// SLOCollector Classifies records as good, bad or ignored and exports event counters
+// plus error ratio and burn rate gauges over several trailing windows.
+type SLOCollector struct {
+	mu          sync.Mutex
+	labelNames  []string
+	ignore      *RecordCondition
+	bad         *RecordCondition
+	errorBudget float64
+	windows     []time.Duration
+	resolution  time.Duration
+	span        time.Duration
+	series      map[string]*sloSeries
+	Events      *prometheus.CounterVec
+	ratioDesc   *prometheus.Desc
+	burnDesc    *prometheus.Desc
+}
+
+type FBSLO struct {
+	Handle *SLOCollector
+}
+
+func (s *FBSLO) NewMetric(p *PluginContext) {
+	shortest, longest := p.SLO.Windows[0], p.SLO.Windows[0]
+	for _, w := range p.SLO.Windows {
+		if w < shortest {
+			shortest = w
+		}
+		if w > longest {
+			longest = w
+		}
+	}
+	resolution := shortest / sloBucketsPerWindow
+	windowLabels := append(append([]string{}, p.VariableLabels...), "window")
+
+	s.Handle = &SLOCollector{
+		labelNames:  p.VariableLabels,
+		ignore:      p.SLO.IgnoreCondition,
+		bad:         p.SLO.BadCondition,
+		errorBudget: 1 - p.SLO.Objective,
+		windows:     p.SLO.Windows,
+		resolution:  resolution,
+		span:        longest,
+		series:      make(map[string]*sloSeries),
+		Events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        p.Name + "_events_total",
+			Help:        p.Help + " (events by outcome)",
+			ConstLabels: p.ConstantLabels,
+		}, append(append([]string{}, p.VariableLabels...), "outcome")),
+		ratioDesc: prometheus.NewDesc(p.Name+"_error_ratio", p.Help+" (bad / (good + bad) over the window)", windowLabels, p.ConstantLabels),
+		burnDesc:  prometheus.NewDesc(p.Name+"_burn_rate", p.Help+" (error ratio / error budget over the window)", windowLabels, p.ConstantLabels),
+	}
+}
+
+// Observe Classify a record and account for it
+func (s *SLOCollector) Observe(labels prometheus.Labels, get func(key string) interface{}) {
+	outcome := "good"
+	switch {
+	case s.ignore != nil && s.ignore.Eval(get):
+		outcome = "ignored"
+	case s.bad.Eval(get):
+		outcome = "bad"
+	}
+
+	l := prometheus.Labels{"outcome": outcome}
+	for k, v := range labels {
+		l[k] = v
+	}
+	s.Events.With(l).Inc()
+
+	if outcome == "ignored" {
+		return
+	}
+
+	values := labelValues(labels, s.labelNames)
+	key := labelsKey(values)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series, ok := s.series[key]
+	if !ok {
+		series = &sloSeries{labelValues: values, ring: newTimeRing(s.resolution, s.span, 2)}
+		s.series[key] = series
+	}
+	if outcome == "bad" {
+		series.ring.Add(time.Now(), sloBad, 1)
+	} else {
+		series.ring.Add(time.Now(), sloGood, 1)
+	}
+}
+
+func (s *SLOCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.Events.Describe(ch)
+	ch <- s.ratioDesc
+	ch <- s.burnDesc
+}
+
+func (s *SLOCollector) Collect(ch chan<- prometheus.Metric) {
+	s.Events.Collect(ch)
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, series := range s.series {
+		for _, w := range s.windows {
+			sums := series.ring.Sum(now, w)
+			good, bad := sums[sloGood], sums[sloBad]
+
+			ratio := math.NaN()
+			if good+bad > 0 {
+				ratio = bad / (good + bad)
+			}
+			lv := append(append([]string{}, series.labelValues...), model.Duration(w).String())
+			ch <- prometheus.MustNewConstMetric(s.ratioDesc, prometheus.GaugeValue, ratio, lv...)
+			ch <- prometheus.MustNewConstMetric(s.burnDesc, prometheus.GaugeValue, ratio/s.errorBudget, lv...)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newSLOCollector(t *testing.T, bad, ignore string) *SLOCollector {
+	p := &PluginContext{}
+	p.Name = "test_slo"
+	p.Help = "test"
+	p.VariableLabels = []string{"route"}
+	p.SLO.Objective = 0.75
+	p.SLO.Windows = []time.Duration{5 * time.Minute, time.Hour}
+
+	var err error
+	if p.SLO.BadCondition, err = ParseRecordCondition(bad); err != nil {
+		t.Fatal(err)
+	}
+	if len(ignore) != 0 {
+		if p.SLO.IgnoreCondition, err = ParseRecordCondition(ignore); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var s FBSLO
+	s.NewMetric(p)
+	return s.Handle
+}
+
+func TestSLOCollector(t *testing.T) {
+	tests := []struct {
+		name     string
+		ignore   string
+		statuses []string
+		want     string
+	}{
+		{
+			name:     "error ratio and burn rate",
+			statuses: []string{"200", "200", "200", "503"},
+			want: `
+# HELP test_slo_burn_rate test (error ratio / error budget over the window)
+# TYPE test_slo_burn_rate gauge
+test_slo_burn_rate{route="/a",window="1h"} 1
+test_slo_burn_rate{route="/a",window="5m"} 1
+# HELP test_slo_error_ratio test (bad / (good + bad) over the window)
+# TYPE test_slo_error_ratio gauge
+test_slo_error_ratio{route="/a",window="1h"} 0.25
+test_slo_error_ratio{route="/a",window="5m"} 0.25
+# HELP test_slo_events_total test (events by outcome)
+# TYPE test_slo_events_total counter
+test_slo_events_total{outcome="bad",route="/a"} 1
+test_slo_events_total{outcome="good",route="/a"} 3
+`,
+		},
+		{
+			name:     "ignored records left out of the ratio",
+			ignore:   "status == 404",
+			statuses: []string{"200", "404", "404", "503"},
+			want: `
+# HELP test_slo_burn_rate test (error ratio / error budget over the window)
+# TYPE test_slo_burn_rate gauge
+test_slo_burn_rate{route="/a",window="1h"} 2
+test_slo_burn_rate{route="/a",window="5m"} 2
+# HELP test_slo_error_ratio test (bad / (good + bad) over the window)
+# TYPE test_slo_error_ratio gauge
+test_slo_error_ratio{route="/a",window="1h"} 0.5
+test_slo_error_ratio{route="/a",window="5m"} 0.5
+# HELP test_slo_events_total test (events by outcome)
+# TYPE test_slo_events_total counter
+test_slo_events_total{outcome="bad",route="/a"} 1
+test_slo_events_total{outcome="good",route="/a"} 1
+test_slo_events_total{outcome="ignored",route="/a"} 2
+`,
+		},
+		{
+			name:     "only ignored records export no ratio",
+			ignore:   "status == 404",
+			statuses: []string{"404"},
+			want: `
+# HELP test_slo_events_total test (events by outcome)
+# TYPE test_slo_events_total counter
+test_slo_events_total{outcome="ignored",route="/a"} 1
+`,
+		},
+		{
+			name:     "missing field is good",
+			statuses: []string{""},
+			want: `
+# HELP test_slo_burn_rate test (error ratio / error budget over the window)
+# TYPE test_slo_burn_rate gauge
+test_slo_burn_rate{route="/a",window="1h"} 0
+test_slo_burn_rate{route="/a",window="5m"} 0
+# HELP test_slo_error_ratio test (bad / (good + bad) over the window)
+# TYPE test_slo_error_ratio gauge
+test_slo_error_ratio{route="/a",window="1h"} 0
+test_slo_error_ratio{route="/a",window="5m"} 0
+# HELP test_slo_events_total test (events by outcome)
+# TYPE test_slo_events_total counter
+test_slo_events_total{outcome="good",route="/a"} 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSLOCollector(t, "status >= 500", tt.ignore)
+			for _, status := range tt.statuses {
+				record := map[string]interface{}{}
+				if len(status) != 0 {
+					record["status"] = status
+				}
+				s.Observe(prometheus.Labels{"route": "/a"}, func(key string) interface{} { return record[key] })
+			}
+			if err := testutil.CollectAndCompare(s, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSLOCollectorWindows(t *testing.T) {
+	s := newSLOCollector(t, "status >= 500", "")
+	labels := prometheus.Labels{"route": "/a"}
+	get := func(status string) func(string) interface{} {
+		return func(string) interface{} { return status }
+	}
+
+	s.Observe(labels, get("200"))
+	// A bad record from 10 minutes ago, outside the short window but inside the long one
+	s.series[labelsKey([]string{"/a"})].ring.Add(time.Now().Add(-10*time.Minute), sloBad, 1)
+
+	want := `
+# HELP test_slo_error_ratio test (bad / (good + bad) over the window)
+# TYPE test_slo_error_ratio gauge
+test_slo_error_ratio{route="/a",window="1h"} 0.5
+test_slo_error_ratio{route="/a",window="5m"} 0
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want), "test_slo_error_ratio"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// StatsDMapping Glob matched against dotted StatsD names, Ex. api.*.latency, mapped to a Prometheus
+// name and labels that may reference the parts matched by each * as $1, $2...
+type StatsDMapping struct {
+	match  *regexp.Regexp
+	name   string
+	labels map[string]string
+}
+
+// ParseStatsDMapping Parse MATCH NAME [label=value,...], Ex. api.*.latency api_latency_seconds route=$1
+func ParseStatsDMapping(s string) (*StatsDMapping, error) {
+	f := strings.Fields(s)
+	if len(f) != 2 && len(f) != 3 {
+		return nil, fmt.Errorf("statsd mapping %q is not MATCH NAME [label=value,...]", s)
+	}
+
+	glob := regexp.QuoteMeta(f[0])
+	// * stands for a single dotted component, as in statsd_exporter
+	glob = strings.Replace(glob, `\*`, `([^.]+)`, -1)
+	m := &StatsDMapping{match: regexp.MustCompile("^" + glob + "$"), name: f[1], labels: map[string]string{}}
+
+	if len(f) == 3 {
+		for _, pair := range strings.Split(f[2], ",") {
+			i := strings.Index(pair, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("statsd mapping %q label %q is not label=value", s, pair)
+			}
+			if !model.LabelName(pair[:i]).IsValid() || pushGroupingLabel(pair[:i]) {
+				return nil, fmt.Errorf("statsd mapping %q label name %q is invalid", s, pair[:i])
+			}
+			m.labels[pair[:i]] = ConfigKeyQuoteTrim(pair[i+1:])
+		}
+	}
+	return m, nil
+}
+
+// Map Return the Prometheus name and labels for a StatsD name, or false when the glob does not match
+func (m *StatsDMapping) Map(name string) (string, map[string]string, bool) {
+	match := m.match.FindStringSubmatchIndex(name)
+	if match == nil {
+		return "", nil, false
+	}
+	expand := func(tmpl string) string {
+		return string(m.match.ExpandString(nil, tmpl, name, match))
+	}
+
+	labels := make(map[string]string, len(m.labels))
+	for k, v := range m.labels {
+		labels[k] = expand(v)
+	}
+	return expand(m.name), labels, true
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// escapeMetricName Turn a StatsD name into a valid Prometheus name, Ex. api.latency into api_latency
+func escapeMetricName(name string) string {
+	name = invalidMetricChars.ReplaceAllString(name, "_")
+	if len(name) != 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// StatsDLine One parsed StatsD or DogStatsD line
+type StatsDLine struct {
+	Name       string
+	Value      float64
+	Type       string
+	Relative   bool
+	SampleRate float64
+	Tags       map[string]string
+	// DroppedTags Tags left out for a reserved name or a value that is not UTF-8
+	DroppedTags int
+}
+
+// ParseStatsDLine Parse name:value|type[|@rate][|#tag:value,...].  Types are c, g, ms, h and d.
+func ParseStatsDLine(s string) (StatsDLine, error) {
+	l := StatsDLine{SampleRate: 1, Tags: map[string]string{}}
+
+	// Tags hold colons too, so split the name off before the first pipe
+	pipe := strings.Index(s, "|")
+	if pipe < 0 {
+		return l, fmt.Errorf("statsd line %q is not name:value|type", s)
+	}
+	i := strings.LastIndex(s[:pipe], ":")
+	if i <= 0 {
+		return l, fmt.Errorf("statsd line %q is not name:value|type", s)
+	}
+	l.Name = s[:i]
+
+	parts := strings.Split(s[i+1:], "|")
+	if len(parts) < 2 {
+		return l, fmt.Errorf("statsd line %q has no type", s)
+	}
+
+	value := parts[0]
+	l.Type = parts[1]
+	switch l.Type {
+	case "c", "ms", "h", "d":
+	case "g":
+		l.Relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	default:
+		return l, fmt.Errorf("statsd line %q has unsupported type %q", s, l.Type)
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return l, fmt.Errorf("statsd line %q: %v", s, err)
+	}
+	l.Value = v
+
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			r, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return l, fmt.Errorf("statsd line %q has invalid sample rate %q", s, p)
+			}
+			l.SampleRate = r
+		case strings.HasPrefix(p, "#"):
+			for _, tag := range strings.Split(p[1:], ",") {
+				name, value := tag, ""
+				// A bare DogStatsD tag has no value
+				if j := strings.Index(tag, ":"); j >= 0 {
+					name, value = tag[:j], tag[j+1:]
+				}
+				name = escapeMetricName(name)
+				// Names starting with __ are reserved by Prometheus, job is set by the push, and values must be UTF-8
+				if len(name) == 0 || strings.HasPrefix(name, "__") || pushGroupingLabel(name) || !utf8.ValidString(value) {
+					l.DroppedTags++
+					continue
+				}
+				l.Tags[name] = value
+			}
+		}
+	}
+	return l, nil
+}
+
+// Observations Number of observations a sampled timer, histogram or distribution line stands for
+func (l StatsDLine) Observations() uint64 {
+	return uint64(math.Round(1 / l.SampleRate))
+}
+
+// StatsDCollector Counters, gauges and histograms created on the fly from StatsD lines
+type StatsDCollector struct {
+	mappings     []*StatsDMapping
+	dropUnmapped bool
+	constLabels  prometheus.Labels
+	metrics      *DynamicMetrics
+	ParseErrors  prometheus.Counter
+	Dropped      prometheus.Counter
+}
+
+type FBStatsD struct {
+	Handle *StatsDCollector
+}
+
+func (f *FBStatsD) NewMetric(p *PluginContext) {
+	f.Handle = &StatsDCollector{
+		mappings:     p.StatsD.Mappings,
+		dropUnmapped: p.StatsD.DropUnmapped,
+		constLabels:  p.ConstantLabels,
+		metrics:      NewDynamicMetrics("Metric ingested from StatsD lines", p.StatsD.Buckets, p.StatsD.MaxSeries, 0),
+		ParseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.Name + "_parse_errors_total",
+			Help:        "StatsD lines in " + p.StatsD.Key + " that could not be parsed, conflict with an earlier type or carry unusable tags",
+			ConstLabels: p.ConstantLabels,
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.Name + "_dropped_series_total",
+			Help:        "StatsD series dropped because " + p.Name + " already holds its maximum number of series",
+			ConstLabels: p.ConstantLabels,
+		}),
+	}
+}
+
+// Ingest Parse one StatsD line and apply it with the record labels added
+func (c *StatsDCollector) Ingest(line string, labels prometheus.Labels) error {
+	l, err := ParseStatsDLine(line)
+	if err == nil && l.Type == "c" && l.Value < 0 {
+		err = fmt.Errorf("statsd counter %s decremented by %v", l.Name, l.Value)
+	}
+	if err != nil {
+		c.ParseErrors.Inc()
+		return err
+	}
+	if l.DroppedTags > 0 {
+		// The line is still applied without the tags
+		c.ParseErrors.Inc()
+	}
+
+	name, mapped := "", false
+	all := prometheus.Labels{}
+	for _, m := range c.mappings {
+		var ml map[string]string
+		if name, ml, mapped = m.Map(l.Name); mapped {
+			for k, v := range ml {
+				all[k] = v
+			}
+			break
+		}
+	}
+	if !mapped {
+		if c.dropUnmapped {
+			return nil
+		}
+		name = escapeMetricName(l.Name)
+	}
+	if !model.IsValidMetricName(model.LabelValue(name)) {
+		c.ParseErrors.Inc()
+		return fmt.Errorf("statsd name %s maps to invalid metric name %q", l.Name, name)
+	}
+	for k, v := range l.Tags {
+		all[k] = v
+	}
+	for k, v := range labels {
+		all[k] = v
+	}
+	for k, v := range c.constLabels {
+		all[k] = v
+	}
+
+	switch {
+	case l.Type == "c":
+		err = c.metrics.Add(name, DynamicCounter, all, l.Value/l.SampleRate)
+	case l.Type == "g" && l.Relative:
+		err = c.metrics.Add(name, DynamicGauge, all, l.Value)
+	case l.Type == "g":
+		err = c.metrics.Set(name, all, l.Value)
+	case l.Type == "ms":
+		// Timers are milliseconds, Prometheus durations are seconds
+		err = c.metrics.Observe(name, all, l.Value/1000, l.Observations())
+	default:
+		err = c.metrics.Observe(name, all, l.Value, l.Observations())
+	}
+
+	if err == errSeriesLimit {
+		c.Dropped.Inc()
+		return nil
+	}
+	if err != nil {
+		c.ParseErrors.Inc()
+	}
+	return err
+}
+
+func (c *StatsDCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.metrics.Describe(ch)
+}
+
+func (c *StatsDCollector) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.Collect(ch)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    StatsDLine
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			line: "api.hits:3|c",
+			want: StatsDLine{Name: "api.hits", Value: 3, Type: "c", SampleRate: 1, Tags: map[string]string{}},
+		},
+		{
+			name: "relative gauge",
+			line: "queue:-2|g",
+			want: StatsDLine{Name: "queue", Value: -2, Type: "g", Relative: true, SampleRate: 1, Tags: map[string]string{}},
+		},
+		{
+			name: "sampled timer with tags",
+			line: "api.latency:32|ms|@0.25|#route:/x,canary",
+			want: StatsDLine{Name: "api.latency", Value: 32, Type: "ms", SampleRate: 0.25, Tags: map[string]string{"route": "/x", "canary": ""}},
+		},
+		{
+			name: "tag value with colons",
+			line: "api.hits:1|c|#url:http://x:80",
+			want: StatsDLine{Name: "api.hits", Value: 1, Type: "c", SampleRate: 1, Tags: map[string]string{"url": "http://x:80"}},
+		},
+		{
+			name: "reserved tag names dropped",
+			line: "api.hits:1|c|#__name__:other,__x,..y:1,env:prod",
+			want: StatsDLine{Name: "api.hits", Value: 1, Type: "c", SampleRate: 1, Tags: map[string]string{"env": "prod"}, DroppedTags: 3},
+		},
+		{
+			name: "job tag dropped",
+			line: "api.hits:1|c|#job:batch,env:prod",
+			want: StatsDLine{Name: "api.hits", Value: 1, Type: "c", SampleRate: 1, Tags: map[string]string{"env": "prod"}, DroppedTags: 1},
+		},
+		{
+			name: "non UTF-8 tag value dropped",
+			line: "api.hits:1|c|#route:\xff\xfe,env:prod",
+			want: StatsDLine{Name: "api.hits", Value: 1, Type: "c", SampleRate: 1, Tags: map[string]string{"env": "prod"}, DroppedTags: 1},
+		},
+		{name: "no type", line: "api.hits:1", wantErr: true},
+		{name: "no name", line: ":1|c", wantErr: true},
+		{name: "unknown type", line: "api.hits:1|s", wantErr: true},
+		{name: "bad value", line: "api.hits:x|c", wantErr: true},
+		{name: "bad rate", line: "api.hits:1|c|@2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatsDLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStatsDLine(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseStatsDLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsDCollectorIngest(t *testing.T) {
+	newCollector := func() *StatsDCollector {
+		p := &PluginContext{}
+		p.Name = "test_statsd"
+		p.StatsD.Buckets = []float64{0.1, 1}
+		p.StatsD.MaxSeries = 2
+		var f FBStatsD
+		f.NewMetric(p)
+		return f.Handle
+	}
+
+	tests := []struct {
+		name        string
+		lines       []string
+		want        string
+		parseErrors float64
+		dropped     float64
+	}{
+		{
+			name:  "sampled counter scaled up",
+			lines: []string{"hits:2|c|@0.5"},
+			want: `
+# HELP hits Metric ingested from StatsD lines
+# TYPE hits counter
+hits 4
+`,
+		},
+		{
+			name:  "sampled timer counts each observation 1/rate times",
+			lines: []string{"latency:50|ms|@0.25", "latency:500|ms"},
+			want: `
+# HELP latency Metric ingested from StatsD lines
+# TYPE latency histogram
+latency_bucket{le="0.1"} 4
+latency_bucket{le="1"} 5
+latency_bucket{le="+Inf"} 5
+latency_sum 0.7
+latency_count 5
+`,
+		},
+		{
+			name:  "series over the cap leave no family behind",
+			lines: []string{"a:1|g", "b:1|g", "c:1|g"},
+			want: `
+# HELP a Metric ingested from StatsD lines
+# TYPE a gauge
+a 1
+# HELP b Metric ingested from StatsD lines
+# TYPE b gauge
+b 1
+`,
+			dropped: 1,
+		},
+		{
+			name:  "job tag dropped and counted, value applied",
+			lines: []string{"hits:1|c|#job:batch,env:prod"},
+			want: `
+# HELP hits Metric ingested from StatsD lines
+# TYPE hits counter
+hits{env="prod"} 1
+`,
+			parseErrors: 1,
+		},
+		{
+			name:  "type conflict keeps the first type",
+			lines: []string{"a:1|c", "a:1|g"},
+			want: `
+# HELP a Metric ingested from StatsD lines
+# TYPE a counter
+a 1
+`,
+			parseErrors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCollector()
+			for _, l := range tt.lines {
+				c.Ingest(l, prometheus.Labels{})
+			}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+			if got := testutil.ToFloat64(c.ParseErrors); got != tt.parseErrors {
+				t.Errorf("parse errors = %v, want %v", got, tt.parseErrors)
+			}
+			if got := testutil.ToFloat64(c.Dropped); got != tt.dropped {
+				t.Errorf("dropped = %v, want %v", got, tt.dropped)
+			}
+			if got := len(c.metrics.families); got != strings.Count(tt.want, "# TYPE") {
+				t.Errorf("%d families held, want %d", got, strings.Count(tt.want, "# TYPE"))
+			}
+		})
+	}
+}
+
+func TestDynamicMetricsCollectSkipsInvalidSeries(t *testing.T) {
+	d := NewDynamicMetrics("test", nil, 0, 0)
+	d.Set("ok", prometheus.Labels{"host": "a"}, 1)
+	d.Set("bad", prometheus.Labels{"host": "\xff"}, 1)
+
+	if got := testutil.CollectAndCount(d); got != 1 {
+		t.Errorf("collected %d series, want 1", got)
+	}
+}
+
+func TestParseStatsDMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       string
+		statsdName string
+		wantName   string
+		wantLabels map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "glob with labels",
+			rule:       "api.*.latency api_latency_seconds route=$1",
+			statsdName: "api.orders.latency",
+			wantName:   "api_latency_seconds",
+			wantLabels: map[string]string{"route": "orders"},
+		},
+		{
+			name:       "star is one component",
+			rule:       "api.*.latency api_latency_seconds",
+			statsdName: "api.orders.v2.latency",
+		},
+		{name: "too few fields", rule: "api.*.latency", wantErr: true},
+		{name: "label without value", rule: "api.* api_total route", wantErr: true},
+		{name: "invalid label name", rule: "api.* api_total 1route=$1", wantErr: true},
+		{name: "job label", rule: "api.* api_total job=$1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseStatsDMapping(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStatsDMapping(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			name, labels, ok := m.Map(tt.statsdName)
+			if ok != (len(tt.wantName) != 0) {
+				t.Fatalf("Map(%q) matched = %v", tt.statsdName, ok)
+			}
+			if ok && (name != tt.wantName || !reflect.DeepEqual(labels, tt.wantLabels)) {
+				t.Errorf("Map(%q) = %s %v, want %s %v", tt.statsdName, name, labels, tt.wantName, tt.wantLabels)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// drainWildcard Token standing in for the variable parts of a template
+	drainWildcard = "<*>"
+	// drainMaxChildren Bound on children per internal tree node, the rest share a wildcard branch
+	drainMaxChildren = 100
+	// drainOverflow Template ID reported once the template cap is reached
+	drainOverflow = "other"
+)
+
+type drainCluster struct {
+	id     string
+	tokens []string
+}
+
+func (c *drainCluster) template() string {
+	return strings.Join(c.tokens, " ")
+}
+
+type drainNode struct {
+	children map[string]*drainNode
+	clusters []*drainCluster
+}
+
+func newDrainNode() *drainNode {
+	return &drainNode{children: make(map[string]*drainNode)}
+}
+
+// Drain Online log template miner, see https://jiemingzhu.github.io/pub/pjhe_icws2017.pdf
+// Messages are routed through a fixed depth tree keyed on token count and leading tokens,
+// then matched against the templates in the leaf by token similarity.
+type Drain struct {
+	root         *drainNode
+	depth        int
+	similarity   float64
+	maxTemplates int
+	clusters     int
+}
+
+func NewDrain(depth int, similarity float64, maxTemplates int) *Drain {
+	return &Drain{
+		root:         newDrainNode(),
+		depth:        depth,
+		similarity:   similarity,
+		maxTemplates: maxTemplates,
+	}
+}
+
+// drainTokenize Split a message on whitespace, masking tokens holding digits as they are nearly always variables
+func drainTokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, t := range tokens {
+		if strings.IndexFunc(t, unicode.IsDigit) >= 0 {
+			tokens[i] = drainWildcard
+		}
+	}
+	return tokens
+}
+
+// Match Return the cluster for a message, creating or generalising one as needed.
+// changed reports whether the template text differs from before, nil is returned once the cap is reached.
+func (d *Drain) Match(message string) (cluster *drainCluster, changed bool) {
+	tokens := drainTokenize(message)
+	// Only look the leaf up here, growing the tree for a message the cap turns away would let
+	// the tree grow without bound
+	leaf := d.leaf(tokens, false)
+
+	var best *drainCluster
+	bestSim := -1.0
+	for _, c := range leafClusters(leaf) {
+		if sim := drainSimilarity(c.tokens, tokens); sim > bestSim {
+			best, bestSim = c, sim
+		}
+	}
+
+	if best != nil && bestSim >= d.similarity {
+		for i, t := range tokens {
+			if best.tokens[i] != t && best.tokens[i] != drainWildcard {
+				best.tokens[i] = drainWildcard
+				changed = true
+			}
+		}
+		return best, changed
+	}
+
+	if d.clusters >= d.maxTemplates {
+		return nil, false
+	}
+	d.clusters++
+	c := &drainCluster{id: strconv.Itoa(d.clusters), tokens: tokens}
+	leaf = d.leaf(tokens, true)
+	leaf.clusters = append(leaf.clusters, c)
+	return c, true
+}
+
+// leaf Walk the tree: token count first, then up to depth-2 leading tokens.  Missing nodes are
+// created when create is set, otherwise nil is returned for a path not in the tree.
+func (d *Drain) leaf(tokens []string, create bool) *drainNode {
+	node := d.child(d.root, strconv.Itoa(len(tokens)), create)
+
+	for i := 0; node != nil && i < d.depth-2 && i < len(tokens); i++ {
+		key := tokens[i]
+		if _, ok := node.children[key]; !ok && len(node.children) >= drainMaxChildren {
+			key = drainWildcard
+		}
+		node = d.child(node, key, create)
+	}
+	return node
+}
+
+func (d *Drain) child(node *drainNode, key string, create bool) *drainNode {
+	c, ok := node.children[key]
+	if !ok && create {
+		c = newDrainNode()
+		node.children[key] = c
+	}
+	return c
+}
+
+// leafClusters Clusters of a leaf, none for a leaf not in the tree
+func leafClusters(leaf *drainNode) []*drainCluster {
+	if leaf == nil {
+		return nil
+	}
+	return leaf.clusters
+}
+
+// drainSimilarity Share of positions holding the same token, both sequences have equal length
+func drainSimilarity(template, tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+	var same int
+	for i, t := range tokens {
+		if template[i] == t {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens))
+}
+
+type FBTemplate struct {
+	Miner     *Drain
+	Handle    *prometheus.CounterVec
+	Info      *prometheus.GaugeVec
+	templates map[string]string
+	label     string
+}
+
+func (t *FBTemplate) NewMetric(p *PluginContext) {
+	t.Miner = NewDrain(p.Template.Depth, p.Template.Similarity, p.Template.MaxTemplates)
+	t.label = p.Template.Label
+	t.templates = make(map[string]string)
+	t.Handle = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        p.Name,
+		Help:        p.Help,
+		ConstLabels: p.ConstantLabels,
+	}, append(append([]string{}, p.VariableLabels...), p.Template.Label))
+	t.Info = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        p.Name + "_info",
+		Help:        "Template text for each " + p.Template.Label + " of " + p.Name,
+		ConstLabels: p.ConstantLabels,
+	}, []string{p.Template.Label, "template"})
+}
+
+// Observe Count a message against its template, keeping the info metric in step with template changes
+func (t *FBTemplate) Observe(labels prometheus.Labels, message string) {
+	id := drainOverflow
+
+	if c, changed := t.Miner.Match(message); c != nil {
+		id = c.id
+		if changed {
+			text := c.template()
+			if old, ok := t.templates[id]; ok {
+				t.Info.DeleteLabelValues(id, old)
+			}
+			t.templates[id] = text
+			t.Info.WithLabelValues(id, text).Set(1)
+		}
+	}
+
+	l := prometheus.Labels{t.label: id}
+	for k, v := range labels {
+		l[k] = v
+	}
+	t.Handle.With(l).Inc()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDrainMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		// want Template of the cluster each message lands in, "" once the cap turns it away
+		want []string
+	}{
+		{
+			name:     "digits masked",
+			messages: []string{"user 42 logged in"},
+			want:     []string{"user <*> logged in"},
+		},
+		{
+			name:     "similar messages generalise one template",
+			messages: []string{"connection to primary lost now", "connection to replica lost now"},
+			want:     []string{"connection to primary lost now", "connection to <*> lost now"},
+		},
+		{
+			name:     "different token counts never share a template",
+			messages: []string{"disk full", "disk full again"},
+			want:     []string{"disk full", "disk full again"},
+		},
+		{
+			name:     "dissimilar messages get their own templates",
+			messages: []string{"cache hit for key", "queue drained for worker"},
+			want:     []string{"cache hit for key", "queue drained for worker"},
+		},
+		{
+			name:     "cap reached",
+			messages: []string{"a b c", "d e f", "g h i", "a b c"},
+			want:     []string{"a b c", "d e f", "", "a b c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDrain(4, 0.5, 2)
+			for i, m := range tt.messages {
+				c, _ := d.Match(m)
+				var got string
+				if c != nil {
+					got = c.template()
+				}
+				if got != tt.want[i] {
+					t.Errorf("Match(%q) template = %q, want %q", m, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDrainTreeBoundedByCap(t *testing.T) {
+	d := NewDrain(4, 0.5, 1)
+	d.Match("first message here")
+	before := drainNodes(d.root)
+
+	for i := 0; i < 1000; i++ {
+		if c, _ := d.Match(fmt.Sprintf("token%c other%c words and more", 'a'+i%26, 'a'+i/26)); c != nil {
+			t.Fatalf("message %d got template %q past the cap", i, c.template())
+		}
+	}
+	if after := drainNodes(d.root); after != before {
+		t.Errorf("tree grew from %d to %d nodes after the cap", before, after)
+	}
+}
+
+// drainNodes Nodes in the tree below node, itself included
+func drainNodes(node *drainNode) int {
+	n := 1
+	for _, c := range node.children {
+		n += drainNodes(c)
+	}
+	return n
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// topKOther Label value collecting everything outside the top K
+const topKOther = "other"
+
+type spaceSavingEntry struct {
+	value string
+	count float64
+	index int
+}
+
+// SpaceSaving Space-Saving heavy hitter sketch holding at most capacity counters.
+// When full, the smallest counter is handed over to the new value, so counts are upper bounds.
+type SpaceSaving struct {
+	capacity int
+	total    float64
+	entries  map[string]*spaceSavingEntry
+	minHeap  spaceSavingHeap
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: capacity,
+		entries:  make(map[string]*spaceSavingEntry),
+	}
+}
+
+func (s *SpaceSaving) Add(value string) {
+	s.total++
+
+	if e, ok := s.entries[value]; ok {
+		e.count++
+		heap.Fix(&s.minHeap, e.index)
+		return
+	}
+
+	if len(s.entries) < s.capacity {
+		e := &spaceSavingEntry{value: value, count: 1}
+		s.entries[value] = e
+		heap.Push(&s.minHeap, e)
+		return
+	}
+
+	e := s.minHeap[0]
+	delete(s.entries, e.value)
+	e.value = value
+	e.count++
+	s.entries[value] = e
+	heap.Fix(&s.minHeap, 0)
+}
+
+// Top Return the k largest counters, largest first
+func (s *SpaceSaving) Top(k int) []spaceSavingEntry {
+	top := make([]spaceSavingEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		top = append(top, *e)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count == top[j].count {
+			return top[i].value < top[j].value
+		}
+		return top[i].count > top[j].count
+	})
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
+
+type spaceSavingHeap []*spaceSavingEntry
+
+func (h spaceSavingHeap) Len() int           { return len(h) }
+func (h spaceSavingHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h spaceSavingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *spaceSavingHeap) Push(x interface{}) {
+	e := x.(*spaceSavingEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *spaceSavingHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+type topKSeries struct {
+	labelValues []string
+	current     *SpaceSaving
+	completed   []spaceSavingEntry
+	other       float64
+}
+
+// TopKCollector Gauge exporting the top K values of a field per label set for the last completed window
+type TopKCollector struct {
+	mu          sync.Mutex
+	desc        *prometheus.Desc
+	labelNames  []string
+	k           int
+	capacity    int
+	window      time.Duration
+	windowStart time.Time
+	series      map[string]*topKSeries
+}
+
+type FBTopK struct {
+	Handle *TopKCollector
+}
+
+func (t *FBTopK) NewMetric(p *PluginContext) {
+	t.Handle = &TopKCollector{
+		desc:        prometheus.NewDesc(p.Name, p.Help, append(append([]string{}, p.VariableLabels...), p.TopK.Label), p.ConstantLabels),
+		labelNames:  p.VariableLabels,
+		k:           p.TopK.K,
+		capacity:    p.TopK.Capacity,
+		window:      p.TopK.Window,
+		windowStart: time.Now(),
+		series:      make(map[string]*topKSeries),
+	}
+}
+
+// rotate Close the current window once it has elapsed.  Label sets without records in it are dropped.
+func (t *TopKCollector) rotate(now time.Time) {
+	if now.Sub(t.windowStart) < t.window {
+		return
+	}
+	stale := now.Sub(t.windowStart) >= 2*t.window
+
+	for key, s := range t.series {
+		if s.current == nil || stale {
+			delete(t.series, key)
+			continue
+		}
+		s.completed = make([]spaceSavingEntry, 0, t.k)
+		s.other = s.current.total
+		for _, e := range s.current.Top(t.k) {
+			// A literal "other" value is folded into the remainder instead of clashing with it
+			if e.value == topKOther {
+				continue
+			}
+			s.completed = append(s.completed, e)
+			s.other -= e.count
+		}
+		if s.other < 0 {
+			s.other = 0
+		}
+		s.current = nil
+	}
+	t.windowStart = now.Truncate(t.window)
+}
+
+func (t *TopKCollector) Observe(labels prometheus.Labels, value string) {
+	values := labelValues(labels, t.labelNames)
+	key := labelsKey(values)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate(time.Now())
+
+	s, ok := t.series[key]
+	if !ok {
+		s = &topKSeries{labelValues: values}
+		t.series[key] = s
+	}
+	if s.current == nil {
+		s.current = NewSpaceSaving(t.capacity)
+	}
+	s.current.Add(value)
+}
+
+func (t *TopKCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.desc
+}
+
+func (t *TopKCollector) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate(time.Now())
+
+	for _, s := range t.series {
+		if s.current != nil && s.completed == nil {
+			// First window for this label set is still open
+			continue
+		}
+		for _, e := range s.completed {
+			ch <- prometheus.MustNewConstMetric(t.desc, prometheus.GaugeValue, e.count, append(append([]string{}, s.labelValues...), e.value)...)
+		}
+		ch <- prometheus.MustNewConstMetric(t.desc, prometheus.GaugeValue, s.other, append(append([]string{}, s.labelValues...), topKOther)...)
+	}
+}
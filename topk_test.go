@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSpaceSavingTop(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		stream   []string
+		k        int
+		want     []spaceSavingEntry
+	}{
+		{
+			name:     "exact below capacity",
+			capacity: 10,
+			stream:   []string{"a", "b", "a", "c", "a", "b"},
+			k:        2,
+			want:     []spaceSavingEntry{{value: "a", count: 3}, {value: "b", count: 2}},
+		},
+		{
+			name:     "ties ordered by value",
+			capacity: 10,
+			stream:   []string{"c", "b", "a"},
+			k:        3,
+			want:     []spaceSavingEntry{{value: "a", count: 1}, {value: "b", count: 1}, {value: "c", count: 1}},
+		},
+		{
+			name:     "k larger than entries",
+			capacity: 10,
+			stream:   []string{"a"},
+			k:        5,
+			want:     []spaceSavingEntry{{value: "a", count: 1}},
+		},
+		{
+			name:     "smallest counter handed over when full",
+			capacity: 2,
+			stream:   []string{"a", "a", "a", "b", "c"},
+			k:        2,
+			// c takes over b's counter of 1, so its count is an upper bound
+			want: []spaceSavingEntry{{value: "a", count: 3}, {value: "c", count: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSpaceSaving(tt.capacity)
+			for _, v := range tt.stream {
+				s.Add(v)
+			}
+			got := s.Top(tt.k)
+			for i := range got {
+				got[i].index = 0
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Top(%d) = %v, want %v", tt.k, got, tt.want)
+			}
+			if s.total != float64(len(tt.stream)) {
+				t.Errorf("total = %v, want %d", s.total, len(tt.stream))
+			}
+		})
+	}
+}
+
+func TestSpaceSavingHeavyHitters(t *testing.T) {
+	// Three heavy values among a long tail of singletons, far more distinct values than counters
+	truth := map[string]int{"hot": 500, "warm": 300, "mild": 200}
+	s := NewSpaceSaving(20)
+	for i := 0; i < 1000; i++ {
+		for v, n := range truth {
+			if i < n {
+				s.Add(v)
+			}
+		}
+		s.Add(fmt.Sprintf("tail-%d", i))
+	}
+
+	top := s.Top(3)
+	min := s.minHeap[0].count
+	for i, want := range []string{"hot", "warm", "mild"} {
+		if top[i].value != want {
+			t.Fatalf("Top(3)[%d] = %s, want %s", i, top[i].value, want)
+		}
+		// Space-Saving overestimates by at most the smallest counter
+		if c := top[i].count; c < float64(truth[want]) || c-min > float64(truth[want]) {
+			t.Errorf("%s count = %v, want between %d and %d + %v", want, c, truth[want], truth[want], min)
+		}
+	}
+}
+
+func TestTopKCollectorRotate(t *testing.T) {
+	p := &PluginContext{}
+	p.Name = "test_topk"
+	p.Help = "test"
+	p.VariableLabels = []string{"host"}
+	p.TopK.Label = "user"
+	p.TopK.K = 2
+	p.TopK.Capacity = 10
+	p.TopK.Window = time.Minute
+
+	var tk FBTopK
+	tk.NewMetric(p)
+	for _, v := range []string{"alice", "alice", "alice", "bob", "bob", "carol", "other"} {
+		tk.Handle.Observe(prometheus.Labels{"host": "h1"}, v)
+	}
+
+	tk.Handle.mu.Lock()
+	defer tk.Handle.mu.Unlock()
+
+	now := time.Now()
+	tk.Handle.rotate(now)
+	s := tk.Handle.series[labelsKey([]string{"h1"})]
+	if s.completed != nil {
+		t.Fatalf("window closed early: %v", s.completed)
+	}
+
+	tk.Handle.rotate(tk.Handle.windowStart.Add(p.TopK.Window))
+	want := []spaceSavingEntry{{value: "alice", count: 3}, {value: "bob", count: 2}}
+	for i := range s.completed {
+		s.completed[i].index = 0
+	}
+	if !reflect.DeepEqual(s.completed, want) {
+		t.Errorf("completed = %v, want %v", s.completed, want)
+	}
+	// carol and the literal other value both fall into the remainder
+	if s.other != 2 {
+		t.Errorf("other = %v, want 2", s.other)
+	}
+
+	// A label set silent for a whole window is forgotten
+	tk.Handle.rotate(tk.Handle.windowStart.Add(p.TopK.Window))
+	if len(tk.Handle.series) != 0 {
+		t.Errorf("series = %d, want 0 after a silent window", len(tk.Handle.series))
+	}
+}
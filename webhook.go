@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Webhook event states
+const (
+	WebhookFiring   = "firing"
+	WebhookResolved = "resolved"
+	WebhookNew      = "new"
+)
+
+// webhookQueueSize Events buffered per target while it is rate limited or retrying
+const webhookQueueSize = 100
+
+// DefaultWebhookTemplate Body sent when a target has no template of its own
+const DefaultWebhookTemplate = `{"name":{{json .Name}},"status":{{json .Status}},"condition":{{json .Condition}},"labels":{{json .Labels}},"value":{{json .Value}},"time":{{json .Time}}}`
+
+// WebhookEvent Data handed to the body template of a target
+type WebhookEvent struct {
+	Name      string
+	Condition string
+	Status    string
+	Metric    string
+	Labels    map[string]string
+	Value     float64
+	Time      time.Time
+}
+
+// webhookCondition Turns the gathered samples into events each time the condition changes state
+type webhookCondition interface {
+	evaluate(samples []Sample, now time.Time) []WebhookEvent
+}
+
+// thresholdCondition EXPR OP THRESHOLD, fires and resolves per series
+type thresholdCondition struct {
+	expr      *MetricExpr
+	op        string
+	threshold float64
+	firing    map[string]Sample
+}
+
+func (c *thresholdCondition) evaluate(samples []Sample, now time.Time) []WebhookEvent {
+	var events []WebhookEvent
+	seen := make(map[string]bool)
+
+	for _, s := range c.expr.Eval(samples, now) {
+		if !compareThreshold(s.Value, c.op, c.threshold) {
+			continue
+		}
+		key := s.key()
+		seen[key] = true
+		if _, ok := c.firing[key]; !ok {
+			events = append(events, WebhookEvent{Status: WebhookFiring, Metric: s.Name, Labels: s.Labels, Value: s.Value})
+		}
+		c.firing[key] = s
+	}
+
+	for key, s := range c.firing {
+		if !seen[key] {
+			events = append(events, WebhookEvent{Status: WebhookResolved, Metric: s.Name, Labels: s.Labels, Value: s.Value})
+			delete(c.firing, key)
+		}
+	}
+	return events
+}
+
+// absentCondition absent(SELECTOR), fires while no series matches
+type absentCondition struct {
+	selector MetricSelector
+	firing   bool
+}
+
+func (c *absentCondition) evaluate(samples []Sample, now time.Time) []WebhookEvent {
+	absent := true
+	for _, s := range samples {
+		if c.selector.Matches(s) {
+			absent = false
+			break
+		}
+	}
+	if absent == c.firing {
+		return nil
+	}
+	c.firing = absent
+
+	status := WebhookResolved
+	if absent {
+		status = WebhookFiring
+	}
+	return []WebhookEvent{{Status: status, Metric: c.selector.Name, Labels: c.selector.Matchers}}
+}
+
+// newValueCondition new_value(SELECTOR,label), one event for every value of label not seen since
+// the plugin started.  The first evaluation only learns the values already present.
+type newValueCondition struct {
+	selector MetricSelector
+	label    string
+	seen     map[string]bool
+	seeded   bool
+}
+
+func (c *newValueCondition) evaluate(samples []Sample, now time.Time) []WebhookEvent {
+	var events []WebhookEvent
+	for _, s := range samples {
+		if !c.selector.Matches(s) {
+			continue
+		}
+		v, ok := s.Labels[c.label]
+		if !ok || c.seen[v] {
+			continue
+		}
+		c.seen[v] = true
+		if c.seeded {
+			events = append(events, WebhookEvent{Status: WebhookNew, Metric: s.Name, Labels: s.Labels, Value: s.Value})
+		}
+	}
+	c.seeded = true
+	return events
+}
+
+// ParseWebhookCondition Parse EXPR OP THRESHOLD, absent(SELECTOR) or new_value(SELECTOR,label)
+func ParseWebhookCondition(s string) (webhookCondition, error) {
+	switch {
+	case strings.HasPrefix(s, "absent(") && strings.HasSuffix(s, ")"):
+		sel, err := ParseMetricSelector(s[len("absent(") : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		return &absentCondition{selector: sel}, nil
+
+	case strings.HasPrefix(s, "new_value(") && strings.HasSuffix(s, ")"):
+		args := s[len("new_value(") : len(s)-1]
+		// The selector may hold commas of its own, the label is after the last one
+		i := strings.LastIndex(args, ",")
+		if i < 0 {
+			return nil, fmt.Errorf("condition %q is not new_value(SELECTOR,label)", s)
+		}
+		sel, err := ParseMetricSelector(strings.TrimSpace(args[:i]))
+		if err != nil {
+			return nil, err
+		}
+		return &newValueCondition{selector: sel, label: strings.TrimSpace(args[i+1:]), seen: make(map[string]bool)}, nil
+	}
+
+	f := SplitUnquoted(s, unicode.IsSpace)
+	if len(f) != 3 {
+		return nil, fmt.Errorf("condition %q is not EXPR OP THRESHOLD, absent(SELECTOR) or new_value(SELECTOR,label)", s)
+	}
+	expr, threshold, err := ParseThreshold(f[0], f[1], f[2])
+	if err != nil {
+		return nil, err
+	}
+	return &thresholdCondition{expr: expr, op: f[1], threshold: threshold, firing: make(map[string]Sample)}, nil
+}
+
+// WebhookTarget One configured webhook: its condition, body template and delivery policy
+type WebhookTarget struct {
+	Name        string
+	URL         string
+	Condition   string
+	Template    *template.Template
+	MinInterval time.Duration
+	Retries     int
+	Backoff     time.Duration
+	condition   webhookCondition
+	queue       chan WebhookEvent
+}
+
+// NewWebhookTarget Parse the condition and body template of a target
+func NewWebhookTarget(name, url, condition, body string) (*WebhookTarget, error) {
+	c, err := ParseWebhookCondition(condition)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: %v", name, err)
+	}
+
+	if len(body) == 0 {
+		body = DefaultWebhookTemplate
+	}
+	t, err := template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: %v", name, err)
+	}
+
+	return &WebhookTarget{
+		Name:      name,
+		URL:       url,
+		Condition: condition,
+		Template:  t,
+		condition: c,
+		queue:     make(chan WebhookEvent, webhookQueueSize),
+	}, nil
+}
+
+// Notifier Evaluates webhook conditions against the plugin registry on a ticker.  Each target
+// delivers its events from its own queue so a slow or failing target never holds up the others.
+type Notifier struct {
+	interval time.Duration
+	targets  []*WebhookTarget
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   log.Logger
+	stop     chan struct{}
+}
+
+func NewNotifier(p *PluginContext, gatherer prometheus.Gatherer) *Notifier {
+	return &Notifier{
+		interval: p.WebhookInterval,
+		targets:  p.Webhooks,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   p.Logger,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (n *Notifier) Run() {
+	for _, t := range n.targets {
+		go n.deliver(t)
+	}
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			n.evaluate(now)
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// Stop End the evaluation ticker and the delivery goroutines when the plugin exits.  Events still
+// queued are dropped.
+func (n *Notifier) Stop() {
+	close(n.stop)
+}
+
+// evaluate Queue the events of every target whose condition changed state
+func (n *Notifier) evaluate(now time.Time) {
+	samples, err := GatherSamples(n.gatherer)
+	if err != nil {
+		level.Error(n.logger).Log("msg", "Webhook evaluation failed", "err", err)
+		return
+	}
+
+	for _, t := range n.targets {
+		for _, e := range t.condition.evaluate(samples, now) {
+			e.Name, e.Condition, e.Time = t.Name, t.Condition, now
+			select {
+			case t.queue <- e:
+			default:
+				level.Warn(n.logger).Log("msg", "Webhook queue full, dropping event", "webhook", t.Name, "status", e.Status)
+			}
+		}
+	}
+}
+
+// deliver Send queued events of a target no more often than its minimum interval
+func (n *Notifier) deliver(t *WebhookTarget) {
+	var last time.Time
+	for {
+		var e WebhookEvent
+		select {
+		case e = <-t.queue:
+		case <-n.stop:
+			return
+		}
+		if !n.sleep(t.MinInterval - time.Since(last)) {
+			return
+		}
+		last = time.Now()
+
+		var body bytes.Buffer
+		if err := t.Template.Execute(&body, e); err != nil {
+			level.Error(n.logger).Log("msg", "Webhook template failed", "webhook", t.Name, "err", err)
+			continue
+		}
+		if err := n.post(t, body.Bytes()); err != nil {
+			level.Error(n.logger).Log("msg", "Webhook delivery failed", "webhook", t.Name, "url", t.URL, "err", err)
+		}
+	}
+}
+
+// post POST the body, retrying network errors, 429 and 5xx responses with exponential backoff
+func (n *Notifier) post(t *WebhookTarget, body []byte) error {
+	backoff := t.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		resp, err = n.client.Post(t.URL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode/100 != 5 {
+				return err
+			}
+		}
+
+		if attempt >= t.Retries {
+			return err
+		}
+		level.Warn(n.logger).Log("msg", "Webhook delivery failed, retrying", "webhook", t.Name, "attempt", attempt+1, "backoff", backoff, "err", err)
+		if !n.sleep(backoff) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// sleep Wait for d, false if the notifier was stopped meanwhile
+func (n *Notifier) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-n.stop:
+		return false
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseWebhookCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		wantErr   bool
+	}{
+		{condition: "queue_depth > 100"},
+		{condition: `errors_total{code="500"} >= 1`},
+		{condition: "absent(up)"},
+		{condition: `new_value(deploys_total{env="prod"},version)`},
+		{condition: "queue_depth >", wantErr: true},
+		{condition: "queue_depth => 1", wantErr: true},
+		{condition: "new_value(deploys_total)", wantErr: true},
+		{condition: "absent(up", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseWebhookCondition(tt.condition); (err != nil) != tt.wantErr {
+			t.Errorf("ParseWebhookCondition(%q) error = %v, wantErr %v", tt.condition, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookConditionEvaluate(t *testing.T) {
+	depth := func(host string, v float64) Sample {
+		return Sample{Name: "queue_depth", Labels: map[string]string{"host": host}, Value: v}
+	}
+	deploy := func(version string) Sample {
+		return Sample{Name: "deploys_total", Labels: map[string]string{"version": version}, Value: 1}
+	}
+
+	tests := []struct {
+		name      string
+		condition string
+		rounds    [][]Sample
+		// want Statuses of the events from each round
+		want [][]string
+	}{
+		{
+			name:      "threshold fires once and resolves per series",
+			condition: "queue_depth > 10",
+			rounds: [][]Sample{
+				{depth("a", 20), depth("b", 5)},
+				{depth("a", 30), depth("b", 20)},
+				{depth("a", 5), depth("b", 20)},
+			},
+			want: [][]string{{WebhookFiring}, {WebhookFiring}, {WebhookResolved}},
+		},
+		{
+			name:      "absent fires while nothing matches",
+			condition: `absent(queue_depth{host="a"})`,
+			rounds:    [][]Sample{{depth("a", 1)}, {depth("b", 1)}, {depth("b", 1)}, {depth("a", 1)}},
+			want:      [][]string{nil, {WebhookFiring}, nil, {WebhookResolved}},
+		},
+		{
+			name:      "new_value learns the values present at the first evaluation",
+			condition: "new_value(deploys_total,version)",
+			rounds:    [][]Sample{{deploy("1.0")}, {deploy("1.0"), deploy("1.1")}, {deploy("1.1")}},
+			want:      [][]string{nil, {WebhookNew}, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseWebhookCondition(tt.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, samples := range tt.rounds {
+				var got []string
+				for _, e := range c.evaluate(samples, time.Now()) {
+					got = append(got, e.Status)
+				}
+				if len(got) != len(tt.want[i]) {
+					t.Fatalf("round %d: events %v, want %v", i, got, tt.want[i])
+				}
+				for j := range got {
+					if got[j] != tt.want[i][j] {
+						t.Errorf("round %d: events %v, want %v", i, got, tt.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+// webhookServer Records the bodies posted to it, answering with the given statuses in turn and 200 after
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   []string
+	times    []time.Time
+	statuses []int
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, string(b))
+		s.times = append(s.times, time.Now())
+		if len(s.statuses) != 0 {
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+		}
+	}))
+	return s
+}
+
+func (s *webhookServer) posts() ([]string, []time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...), append([]time.Time(nil), s.times...)
+}
+
+// waitPosts Wait until the server got n posts or a second went by
+func (s *webhookServer) waitPosts(n int) ([]string, []time.Time) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		bodies, times := s.posts()
+		if len(bodies) >= n || time.Now().After(deadline) {
+			return bodies, times
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newWebhookNotifier(targets ...*WebhookTarget) *Notifier {
+	p := &PluginContext{Logger: log.NewNopLogger()}
+	p.WebhookInterval = time.Hour
+	p.Webhooks = targets
+	return NewNotifier(p, prometheus.NewRegistry())
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		statuses []int
+		retries  int
+		posts    int
+		want     string
+	}{
+		{
+			name:  "default template",
+			posts: 1,
+			want:  `{"name":"queue","status":"firing","condition":"queue_depth \u003e 10","labels":{"host":"a"},"value":20,"time":"2020-01-02T03:04:05Z"}`,
+		},
+		{
+			name:  "custom template",
+			body:  `{"text":"{{.Name}} {{.Status}} on {{index .Labels "host"}}"}`,
+			posts: 1,
+			want:  `{"text":"queue firing on a"}`,
+		},
+		{
+			name:     "5xx retried",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			retries:  2,
+			posts:    3,
+		},
+		{
+			name:     "retries exhausted",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway},
+			retries:  1,
+			posts:    2,
+		},
+		{
+			name:     "4xx not retried",
+			statuses: []int{http.StatusBadRequest},
+			retries:  2,
+			posts:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newWebhookServer(tt.statuses...)
+			defer srv.Close()
+
+			target, err := NewWebhookTarget("queue", srv.URL, "queue_depth > 10", tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target.Retries = tt.retries
+			target.Backoff = time.Millisecond
+			n := newWebhookNotifier(target)
+			defer n.Stop()
+			go n.deliver(target)
+
+			target.queue <- WebhookEvent{
+				Name:      "queue",
+				Condition: "queue_depth > 10",
+				Status:    WebhookFiring,
+				Metric:    "queue_depth",
+				Labels:    map[string]string{"host": "a"},
+				Value:     20,
+				Time:      time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			}
+
+			srv.waitPosts(tt.posts)
+			// Give unwanted retries a chance to show up
+			time.Sleep(20 * time.Millisecond)
+			bodies, _ := srv.posts()
+			if len(bodies) != tt.posts {
+				t.Fatalf("%d posts, want %d", len(bodies), tt.posts)
+			}
+			if len(tt.want) != 0 && bodies[0] != tt.want {
+				t.Errorf("body = %s, want %s", bodies[0], tt.want)
+			}
+			if !json.Valid([]byte(bodies[0])) {
+				t.Errorf("body is not JSON: %s", bodies[0])
+			}
+		})
+	}
+}
+
+func TestWebhookMinInterval(t *testing.T) {
+	srv := newWebhookServer()
+	defer srv.Close()
+
+	target, err := NewWebhookTarget("queue", srv.URL, "queue_depth > 10", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.MinInterval = 50 * time.Millisecond
+	n := newWebhookNotifier(target)
+	defer n.Stop()
+	go n.deliver(target)
+
+	for i := 0; i < 3; i++ {
+		target.queue <- WebhookEvent{Status: WebhookFiring}
+	}
+	_, times := srv.waitPosts(3)
+	if len(times) != 3 {
+		t.Fatalf("%d posts, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		// Allow for timer slack, the point is that posts are not back to back
+		if d := times[i].Sub(times[i-1]); d < 40*time.Millisecond {
+			t.Errorf("post %d came %v after the previous, want at least %v", i, d, target.MinInterval)
+		}
+	}
+}
+
+func TestNotifierStop(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer srv.Close()
+
+	target, err := NewWebhookTarget("queue", srv.URL, "queue_depth > 10", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.MinInterval = time.Hour
+	n := newWebhookNotifier(target)
+	n.interval = time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		n.Run()
+		close(done)
+	}()
+
+	// The second event waits out MinInterval, Stop has to cut that short
+	target.queue <- WebhookEvent{Status: WebhookFiring}
+	target.queue <- WebhookEvent{Status: WebhookResolved}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&posts) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	n.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&posts); got != 1 {
+		t.Errorf("%d posts, want only the one before the minimum interval", got)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type windowBucket struct {
+	count float64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func (b *windowBucket) observe(v float64) {
+	if b.count == 0 || v < b.min {
+		b.min = v
+	}
+	if b.count == 0 || v > b.max {
+		b.max = v
+	}
+	b.count++
+	b.sum += v
+}
+
+func (b *windowBucket) merge(o windowBucket) {
+	if o.count == 0 {
+		return
+	}
+	if b.count == 0 || o.min < b.min {
+		b.min = o.min
+	}
+	if b.count == 0 || o.max > b.max {
+		b.max = o.max
+	}
+	b.count += o.count
+	b.sum += o.sum
+}
+
+type windowSeries struct {
+	labelValues []string
+	current     windowBucket
+	// ring of completed steps, the window is their union
+	steps     []windowBucket
+	next      int
+	published windowBucket
+	ready     bool
+}
+
+// WindowCollector Aggregates an observed field per label set over tumbling or sliding windows.
+// Windows are closed by a ticker rather than by flushes, so a window's boundaries do not depend on
+// when records arrive.  The gauges still only reach the push gateway with a flush.
+type WindowCollector struct {
+	mu         sync.Mutex
+	labelNames []string
+	length     time.Duration
+	steps      int
+	series     map[string]*windowSeries
+	rateDesc   *prometheus.Desc
+	avgDesc    *prometheus.Desc
+	minDesc    *prometheus.Desc
+	maxDesc    *prometheus.Desc
+	countDesc  *prometheus.Desc
+	stop       chan struct{}
+}
+
+type FBWindow struct {
+	Handle *WindowCollector
+}
+
+func (w *FBWindow) NewMetric(p *PluginContext) {
+	desc := func(suffix, help string) *prometheus.Desc {
+		return prometheus.NewDesc(p.Name+suffix, p.Help+" ("+help+")", p.VariableLabels, p.ConstantLabels)
+	}
+
+	w.Handle = &WindowCollector{
+		labelNames: p.VariableLabels,
+		length:     p.Window.Length,
+		steps:      int(p.Window.Length / p.Window.Step),
+		series:     make(map[string]*windowSeries),
+		rateDesc:   desc("_rate_per_second", "records per second over the last window"),
+		avgDesc:    desc("_avg", "average over the last window"),
+		minDesc:    desc("_min", "minimum over the last window"),
+		maxDesc:    desc("_max", "maximum over the last window"),
+		countDesc:  desc("_count", "records in the last window"),
+		stop:       make(chan struct{}),
+	}
+
+	go w.Handle.run(p.Window.Step)
+}
+
+func (w *WindowCollector) run(step time.Duration) {
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.advance()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Stop End the ticker goroutine when the plugin exits
+func (w *WindowCollector) Stop() {
+	close(w.stop)
+}
+
+// advance Close the current step of every series and publish the aggregate of the last window
+func (w *WindowCollector) advance() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, s := range w.series {
+		s.steps[s.next] = s.current
+		s.next = (s.next + 1) % len(s.steps)
+		s.current = windowBucket{}
+
+		var agg windowBucket
+		for _, b := range s.steps {
+			agg.merge(b)
+		}
+		if agg.count == 0 && s.published.count == 0 {
+			// Quiet for a whole window and already reported as such
+			delete(w.series, key)
+			continue
+		}
+		s.published = agg
+		s.ready = true
+	}
+}
+
+func (w *WindowCollector) Observe(labels prometheus.Labels, v float64) {
+	values := labelValues(labels, w.labelNames)
+	key := labelsKey(values)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s, ok := w.series[key]
+	if !ok {
+		s = &windowSeries{labelValues: values, steps: make([]windowBucket, w.steps)}
+		w.series[key] = s
+	}
+	s.current.observe(v)
+}
+
+func (w *WindowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.rateDesc
+	ch <- w.avgDesc
+	ch <- w.minDesc
+	ch <- w.maxDesc
+	ch <- w.countDesc
+}
+
+func (w *WindowCollector) Collect(ch chan<- prometheus.Metric) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, s := range w.series {
+		if !s.ready {
+			continue
+		}
+		b := s.published
+		avg, lo, hi := math.NaN(), math.NaN(), math.NaN()
+		if b.count > 0 {
+			avg, lo, hi = b.sum/b.count, b.min, b.max
+		}
+
+		ch <- prometheus.MustNewConstMetric(w.rateDesc, prometheus.GaugeValue, b.count/w.length.Seconds(), s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(w.avgDesc, prometheus.GaugeValue, avg, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(w.minDesc, prometheus.GaugeValue, lo, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(w.maxDesc, prometheus.GaugeValue, hi, s.labelValues...)
+		ch <- prometheus.MustNewConstMetric(w.countDesc, prometheus.GaugeValue, b.count, s.labelValues...)
+	}
+}